@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ledisdb/redis-failover/failover"
+)
+
+const backupUsage = `Usage: redis-failover backup [options]
+
+Export the masters, group options, maintenance, known slaves and raft peers to a file,
+from the leader of the running cluster with -addr, or from the raft data dir of a stopped node.
+
+Options:
+`
+
+const restoreUsage = `Usage: redis-failover restore [options] <backup file>
+
+Seed the raft data dir of a new node with the backup, run it for every node of the new
+cluster with the same backup and -raft_cluster, then start them with raft_cluster_state existing.
+
+Options:
+`
+
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	addrs := fs.String("addr", "", "redis-failover HTTP addresses, seperated by comma, the backup is read on the leader")
+	dataDir := fs.String("raft_data_dir", "", "read the raft data dir of the stopped node instead")
+	output := fs.String("o", "", "the backup file, default is stdout")
+	timeout := fs.Duration("timeout", 30*time.Second, "HTTP request timeout")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, backupUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return ctlExitUsage
+	}
+
+	if (len(*addrs) == 0) == (len(*dataDir) == 0) || fs.NArg() > 0 {
+		fs.Usage()
+		return ctlExitUsage
+	}
+
+	var data []byte
+	var err error
+	if len(*dataDir) > 0 {
+		var b *failover.Backup
+		if b, err = failover.ReadDataDir(*dataDir); err == nil {
+			data, err = json.Marshal(b)
+		}
+	} else {
+		c := &ctl{addrs: strings.Split(*addrs, ","), client: &http.Client{Timeout: *timeout}}
+		data, err = c.doLeader("GET", "/backup", url.Values{"consistency": {failover.ConsistencyLinearizable}})
+	}
+
+	if err == nil {
+		if len(*output) == 0 {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = ioutil.WriteFile(*output, data, 0644)
+		}
+	}
+
+	switch err {
+	case nil:
+		return ctlExitOK
+	case errNoLeader:
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitNoLeader
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitError
+	}
+}
+
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dataDir := fs.String("raft_data_dir", "", "the raft data dir of the new node")
+	cluster := fs.String("raft_cluster", "", "the raft peers of the new cluster, seperated by comma, default is the peers in the backup")
+	force := fs.Bool("force", false, "remove the old raft logs, snapshots and peers in the data dir")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, restoreUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return ctlExitUsage
+	}
+
+	if len(*dataDir) == 0 || fs.NArg() != 1 {
+		fs.Usage()
+		return ctlExitUsage
+	}
+
+	b, err := failover.ReadBackupFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitError
+	}
+
+	peers := b.Peers
+	if len(*cluster) > 0 {
+		peers = strings.Split(*cluster, ",")
+	}
+
+	if err := failover.RestoreDataDir(*dataDir, b, peers, *force); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitError
+	}
+
+	masters, _ := b.Masters()
+	fmt.Printf("restored %d masters at index %d to %s, peers %s\n", len(masters), b.Index, *dataDir, strings.Join(peers, ","))
+	return ctlExitOK
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ledisdb/redis-failover/failover"
+)
+
+// exit codes for ctl, so scripts can check them
+const (
+	ctlExitOK       = 0
+	ctlExitError    = 1
+	ctlExitUsage    = 2
+	ctlExitNoLeader = 3
+)
+
+var errNoLeader = errors.New("no leader found")
+
+const ctlUsage = `Usage: redis-failover ctl [options] <command> [args]
+
+Commands:
+  status                         show all nodes and the monitored groups
+  masters [list]                 list the monitored masters
+  masters add|del|set <addr>...  change the monitored masters
+  switchover <master> [target]   promote a slave of the alive master
+  failover <master> [target]     approve the failover for the down master,
+                                 use -force if it is refused for data loss
+  groups [list]                  list the options of the groups
+  groups set <master> <k=v>...   set the group options, like max_down_time=30
+  groups clear <master>          clear the group options, use the global config
+  maintenance [list]             list the groups in maintenance
+  maintenance on <master> [duration] [reason]
+                                 suspend failover for the group, like 30m
+  maintenance off <master>       resume failover for the group
+  peers [list]                   list the raft peers
+  peers add|del <peer>           change the raft peers
+  snapshot                       take a raft snapshot on every node now
+  history                        show the failover history of the leader
+  discovery [show]               show the last reconcile of the discovery sources
+  discovery diff                 show the diff with the discovery sources, change nothing
+  discovery sync                 reconcile with the discovery sources now,
+                                 use -force to remove more than max_removal_percent masters
+
+Options:
+`
+
+type ctl struct {
+	addrs       []string
+	json        bool
+	force       bool
+	consistency string
+	client      *http.Client
+	out         io.Writer
+}
+
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	addrs := fs.String("addr", "127.0.0.1:11000", "redis-failover HTTP addresses, seperated by comma, the leader is found automatically")
+	jsonOutput := fs.Bool("json", false, "print JSON instead of tables")
+	timeout := fs.Duration("timeout", 30*time.Second, "HTTP request timeout")
+	force := fs.Bool("force", false, "force the failover even if the candidate loses more data than max_data_loss, or the discovery removing too many masters")
+	consistency := fs.String("consistency", failover.ConsistencyLinearizable, "read the masters with stale, leader or linearizable consistency")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, ctlUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return ctlExitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ctlExitUsage
+	}
+
+	c := &ctl{
+		addrs:       strings.Split(*addrs, ","),
+		json:        *jsonOutput,
+		force:       *force,
+		consistency: *consistency,
+		client:      &http.Client{Timeout: *timeout},
+		out:         os.Stdout,
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+
+	var err error
+	switch cmd {
+	case "status":
+		err = c.status()
+	case "masters":
+		err = c.masters(cmdArgs)
+	case "switchover", "failover":
+		if len(cmdArgs) == 0 || len(cmdArgs) > 2 {
+			fs.Usage()
+			return ctlExitUsage
+		}
+		err = c.switchover(cmd, cmdArgs)
+	case "groups":
+		err = c.groups(cmdArgs)
+	case "maintenance":
+		err = c.maintenance(cmdArgs)
+	case "peers":
+		err = c.peers(cmdArgs)
+	case "snapshot":
+		err = c.snapshot()
+	case "history":
+		err = c.history()
+	case "discovery":
+		err = c.discovery(cmdArgs)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", cmd)
+		fs.Usage()
+		return ctlExitUsage
+	}
+
+	switch err {
+	case nil:
+		return ctlExitOK
+	case errNoLeader:
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitNoLeader
+	case flag.ErrHelp:
+		fs.Usage()
+		return ctlExitUsage
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return ctlExitError
+	}
+}
+
+func (c *ctl) do(method string, addr string, path string, form url.Values) ([]byte, error) {
+	u := fmt.Sprintf("http://%s%s", addr, path)
+
+	// go http server only parses the body form for POST and PUT
+	if form != nil && (method == "GET" || method == "DELETE") {
+		u = fmt.Sprintf("%s?%s", u, form.Encode())
+		form = nil
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s %s", method, u, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
+
+func (c *ctl) getStatus(addr string) (*failover.Status, error) {
+	data, err := c.do("GET", addr, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var s failover.Status
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// leader returns the HTTP address of the leader in the given addresses.
+func (c *ctl) leader() (string, error) {
+	for _, addr := range c.addrs {
+		s, err := c.getStatus(addr)
+		if err != nil {
+			continue
+		}
+
+		if s.IsLeader {
+			return addr, nil
+		}
+	}
+
+	return "", errNoLeader
+}
+
+func (c *ctl) doLeader(method string, path string, form url.Values) ([]byte, error) {
+	addr, err := c.leader()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(method, addr, path, form)
+}
+
+func (c *ctl) printJSON(data []byte) error {
+	_, err := fmt.Fprintln(c.out, strings.TrimSpace(string(data)))
+	return err
+}
+
+func (c *ctl) status() error {
+	type nodeStatus struct {
+		Addr   string           `json:"addr"`
+		Error  string           `json:"error,omitempty"`
+		Status *failover.Status `json:"status,omitempty"`
+	}
+
+	nodes := make([]nodeStatus, 0, len(c.addrs))
+	var leader *failover.Status
+	for _, addr := range c.addrs {
+		s, err := c.getStatus(addr)
+		n := nodeStatus{Addr: addr, Status: s}
+		if err != nil {
+			n.Error = err.Error()
+		} else if s.IsLeader {
+			leader = s
+		}
+		nodes = append(nodes, n)
+	}
+
+	if c.json {
+		data, _ := json.Marshal(nodes)
+		if err := c.printJSON(data); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tROLE\tLEADER\tMASTERS")
+		for _, n := range nodes {
+			if n.Status == nil {
+				fmt.Fprintf(w, "%s\tdown\t-\t%s\n", n.Addr, n.Error)
+				continue
+			}
+
+			role := "follower"
+			if n.Status.IsLeader {
+				role = "leader"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", n.Addr, role, n.Status.Leader, len(n.Status.Masters))
+		}
+		w.Flush()
+
+		if leader != nil {
+			fmt.Fprintln(c.out)
+			w = tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "MASTER\tOFFSET\tCHECK_ERR\tSLAVES")
+			for _, g := range leader.Groups {
+				slaves := make([]string, 0, len(g.Slaves))
+				for _, slave := range g.Slaves {
+					if slave.Health != nil && !slave.Health.Healthy {
+						slaves = append(slaves, fmt.Sprintf("%s(%d, %s)", slave.Addr, slave.Offset, slave.Health.Error))
+					} else {
+						slaves = append(slaves, fmt.Sprintf("%s(%d)", slave.Addr, slave.Offset))
+					}
+				}
+				checkErr := strconv.Itoa(int(g.CheckErrNum))
+				if len(g.CheckError) > 0 {
+					checkErr = fmt.Sprintf("%d(%s)", g.CheckErrNum, g.CheckError)
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", g.Master.Addr, g.Master.Offset, checkErr, strings.Join(slaves, ","))
+			}
+			w.Flush()
+
+			if leader.FailoverPaused {
+				fmt.Fprintln(c.out, "\nfailover is paused, too many groups are down")
+			}
+		}
+	}
+
+	if leader == nil {
+		return errNoLeader
+	}
+	return nil
+}
+
+func (c *ctl) masters(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		data, err := c.doLeader("GET", "/master", url.Values{"consistency": {c.consistency}})
+		if err != nil {
+			return err
+		}
+
+		var masters []string
+		if len(data) > 0 {
+			masters = strings.Split(string(data), ",")
+		}
+
+		if c.json {
+			data, _ = json.Marshal(masters)
+			return c.printJSON(data)
+		}
+
+		for _, master := range masters {
+			fmt.Fprintln(c.out, master)
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+
+	var method string
+	switch args[0] {
+	case "add":
+		method = "POST"
+	case "del":
+		method = "DELETE"
+	case "set":
+		method = "PUT"
+	default:
+		return flag.ErrHelp
+	}
+
+	form := url.Values{"masters": {strings.Join(args[1:], ",")}}
+	_, err := c.doLeader(method, "/master", form)
+	return err
+}
+
+func (c *ctl) switchover(cmd string, args []string) error {
+	form := url.Values{"master": {args[0]}}
+	if len(args) > 1 {
+		form.Set("target", args[1])
+	}
+	if c.force && cmd == "failover" {
+		form.Set("force", "true")
+	}
+
+	data, err := c.doLeader("POST", "/"+cmd, form)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		data, _ = json.Marshal(map[string]string{"master": args[0], "new_master": string(data)})
+		return c.printJSON(data)
+	}
+
+	fmt.Fprintf(c.out, "%s %s to %s ok\n", cmd, args[0], data)
+	return nil
+}
+
+func (c *ctl) groups(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		data, err := c.doLeader("GET", "/groups", nil)
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			return c.printJSON(data)
+		}
+
+		var infos []struct {
+			Master    string                `json:"master"`
+			Effective failover.GroupOptions `json:"effective"`
+		}
+		if err = json.Unmarshal(data, &infos); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MASTER\tCHECK_INTERVAL\tMAX_DOWN_TIME\tELECT\tAUTO_FAILOVER\tOBSERVE_ONLY")
+		for _, info := range infos {
+			o := info.Effective
+			fmt.Fprintf(w, "%s\t%dms\t%ds\t%s\t%v\t%v\n", info.Master, o.CheckInterval, o.MaxDownTime,
+				o.ElectStrategy, o.IsAutoFailover(), o.IsObserveOnly())
+		}
+		return w.Flush()
+	}
+
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+
+	form := url.Values{"master": {args[1]}}
+	switch args[0] {
+	case "set":
+		for _, kv := range args[2:] {
+			seps := strings.SplitN(kv, "=", 2)
+			if len(seps) != 2 {
+				return flag.ErrHelp
+			}
+			form.Set(seps[0], seps[1])
+		}
+		_, err := c.doLeader("PUT", "/groups", form)
+		return err
+	case "clear":
+		_, err := c.doLeader("DELETE", "/groups", form)
+		return err
+	default:
+		return flag.ErrHelp
+	}
+}
+
+func (c *ctl) maintenance(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		data, err := c.doLeader("GET", "/maintenance", nil)
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			return c.printJSON(data)
+		}
+
+		var m map[string]failover.Maintenance
+		if err = json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MASTER\tSINCE\tUNTIL\tREASON")
+		for master, v := range m {
+			until := "-"
+			if !v.Until.IsZero() {
+				until = v.Until.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", master, v.Since.Format(time.RFC3339), until, v.Reason)
+		}
+		return w.Flush()
+	}
+
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+
+	form := url.Values{"masters": {args[1]}}
+	switch args[0] {
+	case "on":
+		if len(args) > 2 {
+			form.Set("duration", args[2])
+		}
+		if len(args) > 3 {
+			form.Set("reason", strings.Join(args[3:], " "))
+		}
+		_, err := c.doLeader("POST", "/maintenance", form)
+		return err
+	case "off":
+		_, err := c.doLeader("DELETE", "/maintenance", form)
+		return err
+	default:
+		return flag.ErrHelp
+	}
+}
+
+func (c *ctl) peers(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		data, err := c.doLeader("GET", "/peers", nil)
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			return c.printJSON(data)
+		}
+
+		var peers []string
+		if err = json.Unmarshal(data, &peers); err != nil {
+			return err
+		}
+
+		for _, peer := range peers {
+			fmt.Fprintln(c.out, peer)
+		}
+		return nil
+	}
+
+	if len(args) != 2 {
+		return flag.ErrHelp
+	}
+
+	var method string
+	switch args[0] {
+	case "add":
+		method = "POST"
+	case "del":
+		method = "DELETE"
+	default:
+		return flag.ErrHelp
+	}
+
+	_, err := c.doLeader(method, "/peers", url.Values{"peer": {args[1]}})
+	return err
+}
+
+// snapshot takes the snapshot on every node, the snapshot is local to the node.
+func (c *ctl) snapshot() error {
+	var failed bool
+	for _, addr := range c.addrs {
+		if _, err := c.do("POST", addr, "/snapshot", nil); err != nil {
+			fmt.Fprintf(c.out, "%s: %v\n", addr, err)
+			failed = true
+		} else {
+			fmt.Fprintf(c.out, "%s: ok\n", addr)
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("snapshot failed on some nodes")
+	}
+	return nil
+}
+
+func (c *ctl) history() error {
+	data, err := c.doLeader("GET", "/history", nil)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	var records []failover.FailoverRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tBEGIN\tREASON\tMASTER\tNEW_MASTER\tDURATION\tTOPOLOGY\tERROR")
+	for _, r := range records {
+		var d time.Duration
+		if !r.End.IsZero() {
+			d = r.End.Sub(r.Begin)
+		}
+		reason := r.Reason
+		if r.DryRun {
+			reason += "(dry run)"
+		}
+		topology := "-"
+		if r.Topology != nil {
+			topology = "unhealthy"
+			if r.Topology.Healthy {
+				topology = "healthy"
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Begin.Format(time.RFC3339),
+			reason, r.Master, r.NewMaster, d, topology, r.Error)
+	}
+	return w.Flush()
+}
+
+func (c *ctl) discovery(args []string) error {
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "show" {
+		data, err = c.doLeader("GET", "/discovery", nil)
+	} else {
+		form := url.Values{}
+		switch args[0] {
+		case "diff":
+			form.Set("dry_run", "true")
+		case "sync":
+			form.Set("force", strconv.FormatBool(c.force))
+		default:
+			return flag.ErrHelp
+		}
+		data, err = c.doLeader("POST", "/discovery", form)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	var r *failover.DiscoveryResult
+	if err = json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+
+	if r == nil {
+		_, err = fmt.Fprintln(c.out, "no discovery yet")
+		return err
+	}
+
+	fmt.Fprintf(c.out, "time: %s, %d masters\n", r.Time.Format(time.RFC3339), len(r.Masters))
+	for _, addr := range r.Added {
+		fmt.Fprintf(c.out, "+ %s\n", addr)
+	}
+	for _, addr := range r.Removed {
+		fmt.Fprintf(c.out, "- %s\n", addr)
+	}
+	if r.Blocked {
+		fmt.Fprintln(c.out, "the removal is blocked, use -force to remove them")
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(c.out, "error: %s\n", e)
+	}
+	return nil
+}
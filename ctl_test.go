@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ledisdb/redis-failover/failover"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ctlTestSuite struct {
+}
+
+var _ = Suite(&ctlTestSuite{})
+
+// fakeNode is a redis-failover node serving the HTTP APIs used by ctl.
+type fakeNode struct {
+	s      *httptest.Server
+	leader bool
+
+	m    sync.Mutex
+	reqs []string
+	// the form of the last request
+	form url.Values
+}
+
+func newFakeNode(leader bool) *fakeNode {
+	n := &fakeNode{leader: leader}
+	n.s = httptest.NewServer(n)
+	return n
+}
+
+func (n *fakeNode) addr() string {
+	return strings.TrimPrefix(n.s.URL, "http://")
+}
+
+func (n *fakeNode) requests() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	return n.reqs
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	n.m.Lock()
+	if r.URL.Path != "/status" {
+		n.reqs = append(n.reqs, r.Method+" "+r.URL.Path)
+		n.form = r.Form
+	}
+	n.m.Unlock()
+
+	switch r.URL.Path {
+	case "/status":
+		json.NewEncoder(w).Encode(failover.Status{Addr: n.addr(), IsLeader: n.leader})
+	case "/master":
+		if r.Method == "GET" {
+			w.Write([]byte("127.0.0.1:6379,127.0.0.1:6380"))
+		}
+	case "/switchover":
+		if len(r.FormValue("master")) == 0 {
+			http.Error(w, "empty master", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("127.0.0.1:6381"))
+	case "/history":
+		begin := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+		json.NewEncoder(w).Encode([]failover.FailoverRecord{{
+			ID:        1,
+			Reason:    failover.FailoverReasonSwitchover,
+			Master:    "127.0.0.1:6379",
+			NewMaster: "127.0.0.1:6381",
+			Begin:     begin,
+			End:       begin.Add(2 * time.Second),
+			Topology:  &failover.TopologyHealth{Healthy: true},
+		}})
+	case "/peers":
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestCtl(nodes ...*fakeNode) (*ctl, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	c := &ctl{client: &http.Client{Timeout: 5 * time.Second}, out: out}
+	for _, n := range nodes {
+		c.addrs = append(c.addrs, n.addr())
+	}
+	return c, out
+}
+
+func (s *ctlTestSuite) TestLeader(c *C) {
+	follower, leader := newFakeNode(false), newFakeNode(true)
+	defer follower.s.Close()
+	defer leader.s.Close()
+
+	// the down node is skipped
+	down := newFakeNode(false)
+	down.s.Close()
+
+	t, out := newTestCtl(down, follower, leader)
+	t.consistency = failover.ConsistencyLinearizable
+
+	addr, err := t.leader()
+	c.Assert(err, IsNil)
+	c.Assert(addr, Equals, leader.addr())
+
+	c.Assert(t.masters(nil), IsNil)
+	c.Assert(out.String(), Equals, "127.0.0.1:6379\n127.0.0.1:6380\n")
+	c.Assert(follower.requests(), HasLen, 0)
+	c.Assert(leader.requests(), DeepEquals, []string{"GET /master"})
+	c.Assert(leader.form.Get("consistency"), Equals, failover.ConsistencyLinearizable)
+
+	t, _ = newTestCtl(down, follower)
+	c.Assert(t.masters(nil), Equals, errNoLeader)
+}
+
+func (s *ctlTestSuite) TestDelete(c *C) {
+	leader := newFakeNode(true)
+	defer leader.s.Close()
+
+	t, _ := newTestCtl(leader)
+
+	// the server ignores the body of DELETE, the form must be in the query
+	c.Assert(t.masters([]string{"del", "127.0.0.1:6379", "127.0.0.1:6380"}), IsNil)
+	c.Assert(leader.requests(), DeepEquals, []string{"DELETE /master"})
+	c.Assert(leader.form.Get("masters"), Equals, "127.0.0.1:6379,127.0.0.1:6380")
+
+	c.Assert(t.peers([]string{"del", "127.0.0.1:12000"}), IsNil)
+	c.Assert(leader.form.Get("peer"), Equals, "127.0.0.1:12000")
+
+	c.Assert(t.masters([]string{"add", "127.0.0.1:6381"}), IsNil)
+	c.Assert(leader.form.Get("masters"), Equals, "127.0.0.1:6381")
+
+	c.Assert(leader.requests(), DeepEquals, []string{"DELETE /master", "DELETE /peers", "POST /master"})
+}
+
+func (s *ctlTestSuite) TestSwitchover(c *C) {
+	leader := newFakeNode(true)
+	defer leader.s.Close()
+
+	t, out := newTestCtl(leader)
+	c.Assert(t.switchover("switchover", []string{"127.0.0.1:6379", "127.0.0.1:6381"}), IsNil)
+	c.Assert(out.String(), Equals, "switchover 127.0.0.1:6379 to 127.0.0.1:6381 ok\n")
+	c.Assert(leader.form.Get("master"), Equals, "127.0.0.1:6379")
+	c.Assert(leader.form.Get("target"), Equals, "127.0.0.1:6381")
+
+	out.Reset()
+	t.json = true
+	c.Assert(t.switchover("switchover", []string{"127.0.0.1:6379"}), IsNil)
+	c.Assert(out.String(), Equals, `{"master":"127.0.0.1:6379","new_master":"127.0.0.1:6381"}`+"\n")
+	c.Assert(leader.form.Get("target"), Equals, "")
+
+	err := t.switchover("switchover", []string{""})
+	c.Assert(err, ErrorMatches, ".*400 Bad Request empty master")
+}
+
+func (s *ctlTestSuite) TestHistory(c *C) {
+	leader := newFakeNode(true)
+	defer leader.s.Close()
+
+	t, out := newTestCtl(leader)
+	c.Assert(t.history(), IsNil)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Assert(strings.Fields(lines[0]), DeepEquals, []string{"ID", "BEGIN", "REASON", "MASTER", "NEW_MASTER", "DURATION", "TOPOLOGY", "ERROR"})
+	c.Assert(strings.Fields(lines[1]), DeepEquals, []string{"1", "2015-06-01T00:00:00Z", failover.FailoverReasonSwitchover,
+		"127.0.0.1:6379", "127.0.0.1:6381", "2s", "healthy"})
+
+	out.Reset()
+	t.json = true
+	c.Assert(t.history(), IsNil)
+	var records []failover.FailoverRecord
+	c.Assert(json.Unmarshal(out.Bytes(), &records), IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].NewMaster, Equals, "127.0.0.1:6381")
+}
@@ -0,0 +1,104 @@
+package failover
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/go/log"
+)
+
+// The node address is host:port, the host may be a hostname,
+// or unix:/path/to/redis.sock for the co-located redis.
+const unixPrefix = "unix:"
+
+// re-resolve the hostname of the node at most every n
+const resolveInterval = 30 * time.Second
+
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
+
+func dialAddr(addr string, connectTimeout, readTimeout, writeTimeout time.Duration) (redis.Conn, error) {
+	if isUnixAddr(addr) {
+		return redis.DialTimeout("unix", strings.TrimPrefix(addr, unixPrefix), connectTimeout, readTimeout, writeTimeout)
+	}
+	return redis.DialTimeout("tcp", addr, connectTimeout, readTimeout, writeTimeout)
+}
+
+// splitAddr returns the host and port for SLAVEOF,
+// other nodes can't replicate from a unix socket.
+func splitAddr(addr string) (string, string, error) {
+	if isUnixAddr(addr) {
+		return "", "", fmt.Errorf("%s is a unix socket, other nodes can't replicate from it", addr)
+	}
+	return net.SplitHostPort(addr)
+}
+
+// validateNodeAddr checks the address is host:port or unix:/path.
+func validateNodeAddr(addr string) error {
+	if isUnixAddr(addr) {
+		if !strings.HasPrefix(strings.TrimPrefix(addr, unixPrefix), "/") {
+			return fmt.Errorf("invalid address %q: unix socket path must be absolute", addr)
+		}
+		return nil
+	}
+	return validateAddr(addr)
+}
+
+// resolve looks up the hostname of the node again if it is time,
+// if the IPs changed, e.g, the pod is rescheduled behind a stable DNS name,
+// the connections are closed so we dial the new IP next time.
+func (n *Node) resolve(now time.Time) {
+	if isUnixAddr(n.Addr) || now.Sub(n.resolvedTime) < resolveInterval {
+		return
+	}
+	n.resolvedTime = now
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return
+	}
+
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		// keep the old IPs, the DNS may be down for a while
+		log.Errorf("resolve %s err %v", n.Addr, err)
+		return
+	}
+	sort.Strings(ips)
+
+	if len(n.ips) > 0 && !reflect.DeepEqual(ips, n.ips) {
+		log.Infof("%s resolves to %v now, was %v, reconnect", n.Addr, ips, n.ips)
+		n.close()
+	}
+	n.ips = ips
+}
+
+// matches returns true if the node is the addr, or its hostname resolved to the addr,
+// e.g, the master reports its slaves with IP in ROLE.
+func (n *Node) matches(addr string) bool {
+	if n.Addr == addr {
+		return true
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if _, p, err := net.SplitHostPort(n.Addr); err != nil || p != port {
+		return false
+	}
+
+	for _, ip := range n.ips {
+		if ip == host {
+			return true
+		}
+	}
+	return false
+}
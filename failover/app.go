@@ -2,25 +2,44 @@ package failover
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/siddontang/go/log"
+	"github.com/siddontang/go/sync2"
 )
 
 var (
 	// If failover handler return this error, we will give up future handling.
 	ErrGiveupFailover = errors.New("Give up failover handling")
+
+	ErrNotLeader = errors.New("node is not leader now")
+)
+
+// The consistency of reading the masters.
+const (
+	// read the local state, it may be stale on the follower
+	ConsistencyStale = "stale"
+	// read on the leader only, it may be stale if the leader is deposed but doesn't know yet
+	ConsistencyLeader = "leader"
+	// read on the leader after a barrier, the state has every write committed before the read
+	ConsistencyLinearizable = "linearizable"
 )
 
 type BeforeFailoverHandler func(downMaster string) error
 type AfterFailoverHandler func(downMaster, newMaster string) error
 
 type App struct {
-	c *Config
+	cMutex sync.RWMutex
+	c      *Config
+
+	configLoader func() (*Config, error)
+	reloadCh     chan struct{}
 
 	l net.Listener
 
@@ -28,8 +47,28 @@ type App struct {
 
 	masters *masterFSM
 
+	events  *eventHub
+	history *failoverHistory
+	limiter *failoverLimiter
+
+	discovery discovery
+	// the fake client in tests, the API server is used if nil
+	kubeClient KubeClient
+
+	// the API server client built from kubeHTTPConfig, protected by kMutex
+	kMutex         sync.Mutex
+	kubeHTTP       *kubeHTTPClient
+	kubeHTTPConfig KubernetesConfig
+
 	gMutex sync.Mutex
 	groups map[string]*Group
+	// redis cluster shard master -> slots, protected by gMutex
+	shards map[string]string
+
+	// only used in app check loop
+	clusterRefreshed time.Time
+	// 1 if refreshing the redis cluster shard masters in background
+	clusterRefreshing sync2.AtomicInt32
 
 	quit chan struct{}
 	wg   sync.WaitGroup
@@ -40,21 +79,32 @@ type App struct {
 }
 
 func NewApp(c *Config) (*App, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
 	var err error
 
+	c.adjust()
+
 	a := new(App)
 	a.c = c
 	a.quit = make(chan struct{})
+	a.reloadCh = make(chan struct{}, 1)
 	a.groups = make(map[string]*Group)
 
+	a.events = newEventHub(eventBufferSize)
+	a.history = newFailoverHistory()
+	a.limiter = newFailoverLimiter()
+
 	a.masters = newMasterFSM()
+	a.masters.onChange = a.onMastersChange
+	a.masters.onMaintenance = a.onMaintenanceChange
 
-	if c.MaxDownTime <= 0 {
-		c.MaxDownTime = 3
-	}
+	a.AddAfterFailoverHandler(a.onKubeFailover)
 
-	if a.c.CheckInterval <= 0 {
-		a.c.CheckInterval = 1000
+	if len(c.LogLevel) > 0 {
+		log.SetLevelByName(c.LogLevel)
 	}
 
 	if len(c.Addr) > 0 {
@@ -70,7 +120,7 @@ func NewApp(c *Config) (*App, error) {
 	case "zk":
 		a.cluster, err = newZk(c, a.masters)
 	default:
-		log.Infof("unsupported broker %s, use no cluster", c.Broker)
+		log.Infof("no broker, use no cluster")
 		a.cluster = nil
 	}
 
@@ -100,27 +150,40 @@ func (a *App) Close() {
 	close(a.quit)
 
 	a.wg.Wait()
+
+	a.events.Close()
 }
 
 func (a *App) Run() {
 	if a.cluster != nil {
 		// wait 5s to determind whether leader or not
 		select {
-		case <-a.cluster.LeaderCh():
+		case b := <-a.cluster.LeaderCh():
+			a.onLeaderChange(b)
 		case <-time.After(5 * time.Second):
 		}
+
+		a.wg.Add(1)
+		go a.watchLeader()
 	}
 
-	if a.c.MastersState == MastersStateNew {
-		a.setMasters(a.c.Masters)
+	c := a.config()
+	if c.MastersState == MastersStateNew {
+		a.setMasters(c.allMasters())
 	} else {
-		a.addMasters(a.c.Masters)
+		a.addMasters(c.allMasters())
 	}
 
+	a.applyGroupConfigs(nil, c.Groups)
+
+	a.wg.Add(1)
+	go a.runDiscovery()
+
 	go a.startHTTP()
 
 	a.wg.Add(1)
-	t := time.NewTicker(time.Duration(a.c.CheckInterval) * time.Millisecond)
+	tick := a.tickInterval()
+	t := time.NewTicker(tick)
 	defer func() {
 		t.Stop()
 		a.wg.Done()
@@ -129,38 +192,90 @@ func (a *App) Run() {
 	for {
 		select {
 		case <-t.C:
-			a.check()
+			a.check(tick)
+		case <-a.reloadCh:
 		case <-a.quit:
 			return
 		}
+
+		// groups may have different check interval, so we use the minimal one
+		if d := a.tickInterval(); d != tick {
+			t.Stop()
+			tick = d
+			t = time.NewTicker(tick)
+		}
 	}
 }
 
-func (a *App) check() {
+func (a *App) tickInterval() time.Duration {
+	interval := a.config().CheckInterval
+	for _, opts := range a.masters.GetAllOptions() {
+		if opts.CheckInterval > 0 && opts.CheckInterval < interval {
+			interval = opts.CheckInterval
+		}
+	}
+
+	return time.Duration(interval) * time.Millisecond
+}
+
+func (a *App) config() *Config {
+	a.cMutex.RLock()
+	defer a.cMutex.RUnlock()
+
+	return a.c
+}
+
+func (a *App) check(tick time.Duration) {
 	if a.cluster != nil && !a.cluster.IsLeader() {
 		// is not leader, not check
 		return
 	}
 
+	c := a.config()
+	now := time.Now()
+
+	a.refreshRedisCluster(c, now)
+
 	masters := a.masters.GetMasters()
 
 	var wg sync.WaitGroup
+	var dm sync.Mutex
+	var downs []*Group
 	for _, master := range masters {
 		a.gMutex.Lock()
 		g, ok := a.groups[master]
 		if !ok {
 			g = newGroup(master)
+			g.events = a.events
+			g.SeedSlaves(a.knownSlaves(c, master))
 			a.groups[master] = g
 		}
 		a.gMutex.Unlock()
 
+		opts := a.masters.GetOptions(master)
+		g.SetOptions(opts, opts.effective(c, a.groupHooks(c, master)))
+
+		if !g.due(now, tick) {
+			continue
+		}
+
 		wg.Add(1)
-		go a.checkMaster(&wg, g)
+		go func(g *Group) {
+			defer wg.Done()
+			if a.checkMaster(g) {
+				dm.Lock()
+				downs = append(downs, g)
+				dm.Unlock()
+			}
+		}(g)
 	}
 
 	// wait all check done
 	wg.Wait()
 
+	// do failover after all checks, so we know how many groups are down now
+	a.failoverGroups(c, downs)
+
 	a.gMutex.Lock()
 	for master, g := range a.groups {
 		if !a.masters.IsMaster(master) {
@@ -171,66 +286,430 @@ func (a *App) check() {
 	a.gMutex.Unlock()
 }
 
-func (a *App) checkMaster(wg *sync.WaitGroup, g *Group) {
-	defer wg.Done()
+// checkMaster checks the group, returns true if the master is down and we need to do failover,
+// the group is still acquired at that time and the caller must release it.
+func (a *App) checkMaster(g *Group) (needFailover bool) {
+	if !g.Acquire() {
+		// a switchover is running now, skip
+		return false
+	}
+	defer func() {
+		if !needFailover {
+			g.Release()
+		}
+	}()
 
 	// later, add check strategy, like check failed n numbers in n seconds and do failover, etc.
 	// now only check once.
 	err := g.Check()
 	if err == nil {
-		return
+		// leave the expired maintenance
+		a.inMaintenance(g.Master.Addr)
+		a.saveSlaves(g)
+		return false
 	}
 
 	oldMaster := g.Master.Addr
 
+	a.events.Publish(&Event{
+		Type:   EventCheckFailed,
+		Master: oldMaster,
+		Data:   map[string]interface{}{"error": err.Error(), "err_num": g.CheckErrNum.Get()},
+	})
+
+	if a.inMaintenance(oldMaster) {
+		log.Warnf("check master %s err %v, in maintenance, skip failover", oldMaster, err)
+		return false
+	}
+
+	_, opts := g.Options()
+
 	if err == ErrNodeType {
+		if opts.IsObserveOnly() {
+			log.Errorf("server %s is not master now, observe only, keep it", oldMaster)
+			return false
+		}
+
 		log.Errorf("server %s is not master now, we will skip it", oldMaster)
 
 		// server is not master, we will not check it.
 		a.delMasters([]string{oldMaster})
-		return
+		return false
 	}
 
-	errNum := time.Duration(g.CheckErrNum.Get())
-	downTime := errNum * time.Duration(a.c.CheckInterval) * time.Millisecond
-	if downTime < time.Duration(a.c.MaxDownTime)*time.Second {
+	downTime := g.downTime()
+	if downTime < time.Duration(opts.MaxDownTime)*time.Second {
 		log.Warnf("check master %s err %v, down time: %0.2fs, retry check", oldMaster, err, downTime.Seconds())
-		return
+		return false
 	}
 
-	// If check error, we will remove it from saved masters and not check.
-	// I just want to avoid some errors if below failover failed, at that time,
-	// handling it manually seems a better way.
-	// If you want to recheck it, please add it again.
-	a.delMasters([]string{oldMaster})
+	if opts.IsObserveOnly() {
+		if g.observed.CompareAndSwap(0, 1) {
+			a.observeFailover(g, err)
+		}
+		return false
+	}
 
-	log.Errorf("check master %s err %v, do failover", oldMaster, err)
+	if g.pending.Get() == 1 {
+		// the failover was refused, wait approving
+		return false
+	}
 
-	if err := a.onBeforeFailover(oldMaster); err != nil {
-		//give up failover
-		return
+	if !opts.IsAutoFailover() {
+		if g.pending.CompareAndSwap(0, 1) {
+			log.Errorf("check master %s err %v, auto failover is disabled, wait approving", oldMaster, err)
+			a.events.Publish(&Event{Type: EventFailoverPending, Master: oldMaster})
+		}
+		return false
 	}
 
-	// first elect a candidate
-	newMaster, err := g.Elect()
+	if _, ok := g.backend().(promoter); !ok && masterReachable(err) && opts.Fence == FenceNone {
+		// the master keeps accepting writes after promoting a slave if it can't be demoted,
+		// e.g, it is busy running a script, so the failover must be fenced or approved
+		if g.pending.CompareAndSwap(0, 1) {
+			log.Errorf("check master %s err %v, can't fence the reachable master, wait approving", oldMaster, err)
+			a.events.Publish(&Event{
+				Type:   EventFailoverPending,
+				Master: oldMaster,
+				Data:   map[string]interface{}{"error": err.Error()},
+			})
+		}
+		return false
+	}
+
+	if _, ok := a.limiter.Cooldown(oldMaster, time.Now()); ok {
+		a.skipFailover(g, SkipReasonCooldown)
+		return false
+	}
+
+	log.Errorf("check master %s err %v, down time: %0.2fs, need failover", oldMaster, err, downTime.Seconds())
+
+	return true
+}
+
+// Failover does failover for the down master manually, it is used for the group
+// which disables auto failover or refused for data loss. If target is empty, we will elect the best one.
+// If force, we will promote the candidate even if it loses more data than max_data_loss.
+func (a *App) Failover(master string, target string, force bool) (string, error) {
+	g, err := a.acquireGroup(master)
 	if err != nil {
-		// elect error
-		return
+		return "", err
+	}
+	defer g.Release()
+
+	// make sure the master is really down
+	if err := g.Check(); err == nil {
+		return "", ErrNodeAlive
+	} else if err == ErrNodeType {
+		return "", err
+	}
+
+	return a.doFailover(g, FailoverReasonManual, target, force)
+}
+
+// observeFailover elects the candidate and reports it, but never does failover.
+func (a *App) observeFailover(g *Group, checkErr error) {
+	oldMaster := g.Master.Addr
+
+	r := FailoverRecord{
+		Reason: FailoverReasonObserve,
+		Master: oldMaster,
+		Begin:  time.Now(),
+		DryRun: true,
 	}
 
-	log.Errorf("master is down, elect %s as new master, do failover", newMaster)
+	c, err := g.ElectCandidate()
+	r.End = time.Now()
+
+	data := map[string]interface{}{"check_error": checkErr.Error()}
+	if err != nil {
+		log.Errorf("observe only, master %s is down, elect err %v, would not failover", oldMaster, err)
+		r.Error = err.Error()
+		data["error"] = err.Error()
+	} else {
+		log.Errorf("observe only, master %s is down, would promote %s: %s", oldMaster, c.Addr, c.Reason)
+		r.NewMaster = c.Addr
+		r.Detail = c.Reason
+		data["reason"] = c.Reason
+		data["priority"] = c.Priority
+		data["offset"] = c.Offset
+	}
+
+	a.history.Add(r)
+
+	a.events.Publish(&Event{
+		Type:   EventWouldFailover,
+		Master: oldMaster,
+		Node:   r.NewMaster,
+		Data:   data,
+	})
+}
+
+func (a *App) acquireGroup(master string) (*Group, error) {
+	if a.cluster != nil && !a.cluster.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	if !a.masters.IsMaster(master) {
+		return nil, fmt.Errorf("%s is not monitored master", master)
+	}
+
+	a.gMutex.Lock()
+	g, ok := a.groups[master]
+	a.gMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("master %s is not checked yet, try later", master)
+	}
+
+	if _, opts := g.Options(); opts.IsObserveOnly() {
+		return nil, fmt.Errorf("master %s is observe only", master)
+	}
+
+	if !g.Acquire() {
+		return nil, ErrGroupBusy
+	}
+
+	return g, nil
+}
+
+// Switchover promotes a slave of the alive master, if target is empty,
+// we will elect the best one.
+func (a *App) Switchover(master string, target string) (string, error) {
+	g, err := a.acquireGroup(master)
+	if err != nil {
+		return "", err
+	}
+	defer g.Release()
+
+	// refresh the slaves and make sure the master is still alive
+	if err := g.Check(); err != nil {
+		return "", err
+	}
+
+	newMaster, err := a.doFailover(g, FailoverReasonSwitchover, target, false)
+	if _, ok := err.(*DemoteError); ok {
+		// the new master is monitored now
+		a.delMasters([]string{master})
+		return newMaster, err
+	} else if err != nil {
+		return "", err
+	}
+
+	a.delMasters([]string{master})
+	return newMaster, nil
+}
+
+// doFailover promotes the target or the elected slave to master, the caller must acquire the group.
+// For the down master, it is removed from the monitored masters unless the failover is refused for data loss.
+// The reachable master is kept if no slave is promoted. If the slave is promoted but the reachable master
+// can't be demoted, the new master is monitored and returned with a DemoteError.
+func (a *App) doFailover(g *Group, reason string, target string, force bool) (newMaster string, err error) {
+	oldMaster := g.Master.Addr
+
+	r := a.history.Begin(oldMaster, reason)
+
+	a.events.Publish(&Event{
+		Type:   EventFailoverBegin,
+		Master: oldMaster,
+		Data:   map[string]interface{}{"reason": reason},
+	})
+
+	defer func() {
+		a.history.End(r, newMaster, err)
+		if err != nil {
+			a.publishFailoverFailed(oldMaster, err)
+		}
+	}()
+
+	// the master failing the probes can still be reached, promote a slave like switchover
+	alive := reason == FailoverReasonSwitchover || masterReachable(g.CheckError())
+	promoted := false
+
+	if reason != FailoverReasonSwitchover {
+		// If check error, we will remove it from saved masters and not check.
+		// I just want to avoid some errors if below failover failed, at that time,
+		// handling it manually seems a better way.
+		// If you want to recheck it, please add it again.
+		defer func() {
+			if _, ok := err.(*DataLossError); ok {
+				return
+			}
+
+			if alive && err != nil && !promoted {
+				// the master is still alive, keep checking it
+				return
+			}
+
+			a.delMasters([]string{oldMaster})
+		}()
+	}
+
+	rawOpts, opts := g.Options()
+
+	if err = a.onBeforeFailover(oldMaster, opts.Hooks); err != nil {
+		//give up failover
+		return "", err
+	}
+
+	newMaster = target
+	if len(newMaster) == 0 {
+		// first elect a candidate
+		if alive {
+			newMaster, err = g.ElectAlive()
+		} else {
+			newMaster, err = g.Elect()
+		}
+
+		if err != nil {
+			// elect error
+			return "", err
+		}
+	}
+
+	log.Errorf("master %s %s, elect %s as new master, do failover", oldMaster, reason, newMaster)
+
+	a.events.Publish(&Event{Type: EventFailoverElect, Master: oldMaster, Node: newMaster})
+
+	if !alive && (opts.CatchUpTimeout > 0 || opts.MaxDataLoss > 0) {
+		maxLoss := opts.MaxDataLoss
+		if force {
+			maxLoss = 0
+		}
+
+		var loss int64
+		loss, err = g.CatchUp(newMaster, time.Duration(opts.CatchUpTimeout)*time.Second, maxLoss)
+		a.history.Update(r, func(r *FailoverRecord) { r.DataLoss = loss })
+		if err != nil {
+			log.Errorf("master %s failover, candidate %s catch up err %v", oldMaster, newMaster, err)
+			return "", err
+		}
+	}
+
+	var f *fence
+	if !alive && opts.Fence != FenceNone {
+		// clients may still write to the down master if only we are partitioned from it
+		if f = g.tryFence(opts.Fence, time.Duration(opts.FenceTimeout)*time.Second); f != nil {
+			defer f.close()
+		}
+	}
 
 	// promote the candiate to master
-	err = g.Promote(newMaster)
+	if alive {
+		err = g.Switchover(newMaster)
+	} else {
+		err = g.Promote(newMaster)
+	}
+
+	var demoteErr error
+	if _, ok := err.(*DemoteError); ok {
+		// two masters now, the new one must be monitored
+		log.Errorf("master %s failover err: %v", oldMaster, err)
+		demoteErr, err = err, nil
+	}
 
 	if err != nil {
+		if f != nil {
+			f.release()
+		}
+
 		log.Fatalf("do master %s failover err: %v", oldMaster, err)
-		return
+		return "", err
+	}
+
+	promoted = true
+
+	if f != nil {
+		// the down master is still reachable by others, let it follow the new master
+		if err := g.demoteFenced(f, newMaster); err != nil {
+			log.Errorf("demote fenced old master %s err %v, keep the fence %s", oldMaster, err, f)
+			a.history.Update(r, func(r *FailoverRecord) { r.Fence = f.String() })
+		}
 	}
 
 	a.addMasters([]string{newMaster})
 
-	a.onAfterFailover(oldMaster, newMaster)
+	// the new leader can still do failover if the new master is down before its first check
+	a.saveSlaves(g)
+
+	// avoid failing over again and again if the network is flaky
+	if opts.FailoverCooldown > 0 {
+		a.limiter.SetCooldown([]string{oldMaster, newMaster}, time.Now().Add(time.Duration(opts.FailoverCooldown)*time.Second))
+	}
+
+	// the new master keeps the group options
+	if rawOpts != (GroupOptions{}) {
+		if err := a.setGroupOptions([]string{newMaster}, &rawOpts); err != nil {
+			log.Errorf("set options for new master %s err %v", newMaster, err)
+		}
+	}
+
+	a.events.Publish(&Event{Type: EventFailoverDone, Master: oldMaster, Node: newMaster})
+
+	a.onAfterFailover(oldMaster, newMaster, opts.Hooks)
+
+	a.verifyTopology(g, r, time.Duration(opts.VerifyTimeout)*time.Second)
+
+	err = demoteErr
+	return newMaster, err
+}
+
+// verifyTopology waits the slaves to replicate from the new master and saves the result in the record.
+func (a *App) verifyTopology(g *Group, r *FailoverRecord, timeout time.Duration) {
+	h := g.Verify(timeout)
+	a.history.Update(r, func(r *FailoverRecord) { r.Topology = h })
+
+	if h.Healthy {
+		log.Infof("master %s with %d slaves is healthy after failover", h.Master, h.ConnectedSlaves)
+	} else {
+		log.Errorf("master %s is unhealthy after failover, %d slaves connected, %v", h.Master, h.ConnectedSlaves, h.Slaves)
+	}
+
+	a.events.Publish(&Event{
+		Type:   EventTopologyVerified,
+		Master: r.Master,
+		Node:   h.Master,
+		Data:   map[string]interface{}{"healthy": h.Healthy, "connected_slaves": h.ConnectedSlaves, "slaves": h.Slaves},
+	})
+}
+
+func (a *App) publishFailoverFailed(master string, err error) {
+	a.events.Publish(&Event{
+		Type:   EventFailoverFailed,
+		Master: master,
+		Data:   map[string]interface{}{"error": err.Error()},
+	})
+}
+
+func (a *App) watchLeader() {
+	defer a.wg.Done()
+
+	for {
+		select {
+		case b := <-a.cluster.LeaderCh():
+			a.onLeaderChange(b)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+func (a *App) onLeaderChange(isLeader bool) {
+	log.Infof("%s leader changed, is leader: %v", a.config().Addr, isLeader)
+
+	a.events.Publish(&Event{
+		Type: EventLeaderChanged,
+		Data: map[string]interface{}{"leader": isLeader},
+	})
+}
+
+func (a *App) onMastersChange(added []string, removed []string) {
+	for _, master := range added {
+		a.events.Publish(&Event{Type: EventMasterAdded, Master: master})
+	}
+
+	for _, master := range removed {
+		a.events.Publish(&Event{Type: EventMasterRemoved, Master: master})
+	}
 }
 
 func (a *App) startHTTP() {
@@ -241,6 +720,18 @@ func (a *App) startHTTP() {
 	m := mux.NewRouter()
 
 	m.Handle("/master", &masterHandler{a})
+	m.Handle("/events", &eventHandler{a})
+	m.Handle("/status", &statusHandler{a})
+	m.Handle("/peers", &peerHandler{a})
+	m.Handle("/snapshot", &snapshotHandler{a})
+	m.Handle("/backup", &backupHandler{a})
+	m.Handle("/switchover", &switchoverHandler{a})
+	m.Handle("/history", &historyHandler{a})
+	m.Handle("/reload", &reloadHandler{a})
+	m.Handle("/groups", &groupHandler{a})
+	m.Handle("/failover", &failoverHandler{a})
+	m.Handle("/maintenance", &maintenanceHandler{a})
+	m.Handle("/discovery", &discoveryHandler{a})
 
 	s := http.Server{
 		Handler: m,
@@ -249,22 +740,141 @@ func (a *App) startHTTP() {
 	s.Serve(a.l)
 }
 
+type Status struct {
+	Addr     string        `json:"addr"`
+	Broker   string        `json:"broker"`
+	IsLeader bool          `json:"is_leader"`
+	Leader   string        `json:"leader"`
+	Masters  []string      `json:"masters"`
+	Groups   []GroupStatus `json:"groups"`
+	// true if too many groups are down and the failover is paused
+	FailoverPaused bool `json:"failover_paused"`
+}
+
+// GetMasters returns the monitored masters with the consistency, empty means stale.
+func (a *App) GetMasters(consistency string) ([]string, error) {
+	if err := a.readBarrier(consistency); err != nil {
+		return nil, err
+	}
+
+	masters := a.masters.GetMasters()
+	sort.Strings(masters)
+	return masters, nil
+}
+
+// readBarrier returns nil if we can read the local state with the consistency.
+func (a *App) readBarrier(consistency string) error {
+	switch consistency {
+	case "", ConsistencyStale:
+	case ConsistencyLeader, ConsistencyLinearizable:
+		if a.cluster == nil {
+			break
+		}
+
+		if !a.cluster.IsLeader() {
+			return ErrNotLeader
+		}
+
+		if consistency != ConsistencyLinearizable {
+			break
+		}
+
+		// the barrier is committed only if we are still the leader,
+		// and it returns after all the committed logs are applied
+		if err := a.cluster.Barrier(10 * time.Second); err != nil {
+			if !a.cluster.IsLeader() {
+				return ErrNotLeader
+			}
+			return err
+		}
+	default:
+		return validateConsistency(consistency)
+	}
+
+	return nil
+}
+
+func validateConsistency(consistency string) error {
+	switch consistency {
+	case "", ConsistencyStale, ConsistencyLeader, ConsistencyLinearizable:
+		return nil
+	default:
+		return fmt.Errorf("consistency must be %s, %s or %s, not %q", ConsistencyStale, ConsistencyLeader, ConsistencyLinearizable, consistency)
+	}
+}
+
+// Status returns the node state, only the leader has the checked groups.
+func (a *App) Status() Status {
+	c := a.config()
+
+	var s Status
+	s.Addr = c.Addr
+	s.Broker = c.Broker
+
+	if a.cluster != nil {
+		s.IsLeader = a.cluster.IsLeader()
+		s.Leader = a.cluster.Leader()
+	} else {
+		s.IsLeader = true
+	}
+
+	s.FailoverPaused = a.limiter.IsOpen()
+
+	s.Masters = a.masters.GetMasters()
+	sort.Strings(s.Masters)
+
+	a.gMutex.Lock()
+	groups := make([]*Group, 0, len(a.groups))
+	for _, g := range a.groups {
+		groups = append(groups, g)
+	}
+	a.gMutex.Unlock()
+
+	s.Groups = make([]GroupStatus, 0, len(groups))
+	for _, g := range groups {
+		gs := g.Status()
+		gs.Maintenance = a.masters.GetMaintenance(gs.Master.Addr)
+		if until, ok := a.limiter.Cooldown(gs.Master.Addr, time.Now()); ok {
+			gs.CooldownUntil = &until
+		}
+		a.gMutex.Lock()
+		gs.Slots = a.shards[gs.Master.Addr]
+		a.gMutex.Unlock()
+		s.Groups = append(s.Groups, gs)
+	}
+	sort.Slice(s.Groups, func(i, j int) bool { return s.Groups[i].Master.Addr < s.Groups[j].Master.Addr })
+
+	return s
+}
+
+func (a *App) raft() (*Raft, error) {
+	r, ok := a.cluster.(*Raft)
+	if !ok || r == nil {
+		return nil, fmt.Errorf("only supported by raft broker")
+	}
+	return r, nil
+}
+
 func (a *App) addMasters(addrs []string) error {
+	return a.addMastersWithOptions(addrs, nil)
+}
+
+// addMastersWithOptions adds the masters, the new ones get the options in the same command.
+func (a *App) addMastersWithOptions(addrs []string, opts *GroupOptions) error {
 	if len(addrs) == 0 {
 		return nil
 	}
 
 	if a.cluster != nil {
 		if a.cluster.IsLeader() {
-			return a.cluster.AddMasters(addrs, 10*time.Second)
+			return a.cluster.AddMastersWithOptions(addrs, opts, 10*time.Second)
 		} else {
-			log.Infof("%s is not leader, skip", a.c.Addr)
+			log.Infof("%s is not leader, skip", a.config().Addr)
 		}
 	} else {
-		a.masters.AddMasters(addrs)
+		a.masters.AddMastersWithOptions(addrs, opts)
 	}
 	return nil
-
 }
 
 func (a *App) delMasters(addrs []string) error {
@@ -276,7 +886,7 @@ func (a *App) delMasters(addrs []string) error {
 		if a.cluster.IsLeader() {
 			return a.cluster.DelMasters(addrs, 10*time.Second)
 		} else {
-			log.Infof("%s is not leader, skip", a.c.Addr)
+			log.Infof("%s is not leader, skip", a.config().Addr)
 		}
 	} else {
 		a.masters.DelMasters(addrs)
@@ -289,7 +899,7 @@ func (a *App) setMasters(addrs []string) error {
 		if a.cluster.IsLeader() {
 			return a.cluster.SetMasters(addrs, 10*time.Second)
 		} else {
-			log.Infof("%s is not leader, skip", a.c.Addr)
+			log.Infof("%s is not leader, skip", a.config().Addr)
 		}
 	} else {
 		a.masters.SetMasters(addrs)
@@ -297,6 +907,89 @@ func (a *App) setMasters(addrs []string) error {
 	return nil
 }
 
+func (a *App) setGroupOptions(addrs []string, opts *GroupOptions) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	if a.cluster != nil {
+		if a.cluster.IsLeader() {
+			return a.cluster.SetGroupOptions(addrs, opts, 10*time.Second)
+		} else {
+			log.Infof("%s is not leader, skip", a.config().Addr)
+		}
+	} else {
+		a.masters.SetOptions(addrs, opts)
+	}
+	return nil
+}
+
+// knownSlaves returns the slaves saved in cluster, or the slaves in config if none.
+func (a *App) knownSlaves(c *Config, master string) []string {
+	if slaves := a.masters.GetSlaves(master); len(slaves) > 0 {
+		return slaves
+	}
+
+	for _, g := range c.Groups {
+		if g.Master == master {
+			return g.Slaves
+		}
+	}
+	return nil
+}
+
+// groupHooks returns the hooks in config for the master, the old master of the group
+// in config is a slave of the master after failover.
+func (a *App) groupHooks(c *Config, master string) HooksConfig {
+	return c.groupHooks(append([]string{master}, a.masters.GetSlaves(master)...))
+}
+
+// saveSlaves saves the slaves of the group in cluster if changed.
+func (a *App) saveSlaves(g *Group) {
+	master, slaves := g.SlaveAddrs()
+
+	added, removed := diffStrings(a.masters.GetSlaves(master), slaves)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	a.setSlaves(master, slaves)
+}
+
+func (a *App) setSlaves(master string, slaves []string) {
+	if a.cluster != nil {
+		if err := a.cluster.SetSlaves(master, slaves, 10*time.Second); err != nil {
+			log.Errorf("save slaves %v of master %s err %v", slaves, master, err)
+		}
+	} else {
+		a.masters.SetSlaves(master, slaves)
+	}
+}
+
+// applyGroupConfigs saves the options of groups in config, and removes the options
+// of the groups which are only in the old config.
+func (a *App) applyGroupConfigs(old []GroupConfig, groups []GroupConfig) error {
+	m := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		m[g.Master] = struct{}{}
+
+		opts := g.GroupOptions
+		if err := a.setGroupOptions([]string{g.Master}, &opts); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range old {
+		if _, ok := m[g.Master]; !ok {
+			if err := a.setGroupOptions([]string{g.Master}, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (a *App) AddBeforeFailoverHandler(f BeforeFailoverHandler) {
 	a.hMutex.Lock()
 	a.beforeHandlers = append(a.beforeHandlers, f)
@@ -309,7 +1002,7 @@ func (a *App) AddAfterFailoverHandler(f AfterFailoverHandler) {
 	a.hMutex.Unlock()
 }
 
-func (a *App) onBeforeFailover(downMaster string) error {
+func (a *App) onBeforeFailover(downMaster string, hooks HooksConfig) error {
 	a.hMutex.Lock()
 	defer a.hMutex.Unlock()
 
@@ -322,10 +1015,15 @@ func (a *App) onBeforeFailover(downMaster string) error {
 		}
 	}
 
+	timeout := time.Duration(hooks.Timeout) * time.Second
+	if err := runHook("before_failover", hooks.BeforeFailover, timeout, downMaster); err != nil {
+		log.Errorf("do before failover hook for %s err: %v", downMaster, err)
+	}
+
 	return nil
 }
 
-func (a *App) onAfterFailover(downMaster string, newMaster string) error {
+func (a *App) onAfterFailover(downMaster string, newMaster string, hooks HooksConfig) error {
 	a.hMutex.Lock()
 	defer a.hMutex.Unlock()
 
@@ -338,5 +1036,10 @@ func (a *App) onAfterFailover(downMaster string, newMaster string) error {
 		}
 	}
 
+	timeout := time.Duration(hooks.Timeout) * time.Second
+	if err := runHook("after_failover", hooks.AfterFailover, timeout, downMaster, newMaster); err != nil {
+		log.Errorf("do after failover hook for %s -> %s err: %v", downMaster, newMaster, err)
+	}
+
 	return nil
 }
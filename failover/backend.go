@@ -0,0 +1,279 @@
+package failover
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	BackendRedis = "redis"
+	BackendLedis = "ledis"
+	// the shard masters of redis cluster
+	BackendCluster = "cluster"
+)
+
+// doFunc sends a command to the node.
+type doFunc func(cmd string, args ...interface{}) (interface{}, error)
+
+// RoleInfo is the role of a node.
+type RoleInfo struct {
+	// master or slave
+	Type string
+	// the replication offset of the master
+	Offset int64
+	// the slaves of the master
+	Slaves []NodeStatus
+}
+
+// ReplInfo is the replication state of a slave.
+type ReplInfo struct {
+	// master or slave
+	Role       string
+	MasterHost string
+	MasterPort string
+	LinkUp     bool
+	Syncing    bool
+	// the replication offset the slave has processed
+	Offset   int64
+	Priority int
+	// seconds since the last interaction with master, -1 if unknown
+	LastIOSeconds int
+}
+
+// A Backend knows how to monitor and fail over one kind of server.
+type Backend interface {
+	Role(do doFunc) (*RoleInfo, error)
+	ReplInfo(do doFunc) (*ReplInfo, error)
+	// SlaveOf lets the node replicate from host:port, "no one" promotes it to master
+	SlaveOf(do doFunc, host string, port string) error
+}
+
+// A promoter promotes the slave by itself instead of SLAVEOF NO ONE,
+// the other slaves and the old master follow the new master automatically.
+type promoter interface {
+	Promote(do doFunc, masterAlive bool) error
+	// MasterSlots returns the slots the new master must own after promoting
+	MasterSlots(do doFunc) (string, error)
+}
+
+var backends = map[string]Backend{
+	BackendRedis:   redisBackend{},
+	BackendLedis:   ledisBackend{},
+	BackendCluster: clusterBackend{},
+}
+
+// getBackend returns the redis backend if name is empty.
+func getBackend(name string) Backend {
+	if b, ok := backends[name]; ok {
+		return b
+	}
+	return backends[BackendRedis]
+}
+
+func validateBackend(name string) error {
+	switch name {
+	case "", BackendRedis, BackendLedis, BackendCluster:
+		return nil
+	default:
+		return fmt.Errorf("backend: must be %s, %s or %s, not %q", BackendRedis, BackendLedis, BackendCluster, name)
+	}
+}
+
+func doInfo(do doFunc, section string) (map[string]string, error) {
+	v, err := redis.String(do("INFO", section))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInfo(v), nil
+}
+
+func parseInfo(v string) map[string]string {
+	seps := strings.Split(v, "\r\n")
+	// skip first line, is the section name, like # Replication
+	seps = seps[1:]
+
+	m := make(map[string]string, len(seps))
+	for _, s := range seps {
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) == 2 {
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return m
+}
+
+// parseRole parses the ROLE reply of the master, redis and ledis use the same format.
+func parseRole(v []interface{}) (*RoleInfo, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("invalid ROLE reply %v", v)
+	}
+
+	r := new(RoleInfo)
+
+	// the first line is server type
+	r.Type, _ = redis.String(v[0], nil)
+	if r.Type != MasterType || len(v) < 3 {
+		return r, nil
+	}
+
+	// second is master replication offset,
+	r.Offset, _ = redis.Int64(v[1], nil)
+
+	// then slave list [host, port, offset]
+	slaves, _ := redis.Values(v[2], nil)
+	for i := 0; i < len(slaves); i++ {
+		ss, _ := redis.Values(slaves[i], nil)
+		if len(ss) < 3 {
+			continue
+		}
+
+		var n NodeStatus
+		n.Addr = fmt.Sprintf("%s:%s", replyString(ss[0]), replyString(ss[1]))
+		n.Offset, _ = strconv.ParseInt(replyString(ss[2]), 10, 64)
+		r.Slaves = append(r.Slaves, n)
+	}
+
+	return r, nil
+}
+
+// replyString returns the bulk or integer reply as string.
+func replyString(v interface{}) string {
+	if i, ok := v.(int64); ok {
+		return strconv.FormatInt(i, 10)
+	}
+
+	s, _ := redis.String(v, nil)
+	return s
+}
+
+type redisBackend struct{}
+
+// Role uses ROLE, or INFO replication if the server doesn't support ROLE,
+// like redis before 2.8.12 and some redis compatible servers.
+func (redisBackend) Role(do doFunc) (*RoleInfo, error) {
+	v, err := redis.Values(do("ROLE"))
+	if err == nil {
+		return parseRole(v)
+	}
+
+	if !unknownCommand(err) {
+		return nil, err
+	}
+
+	return redisBackend{}.infoRole(do)
+}
+
+// infoRole gets the role with INFO replication.
+func (redisBackend) infoRole(do doFunc) (*RoleInfo, error) {
+	m, err := doInfo(do, "REPLICATION")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInfoRole(m), nil
+}
+
+// unknownCommand returns true if the server doesn't support the command.
+func unknownCommand(err error) bool {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return false
+	}
+
+	s := strings.ToLower(string(e))
+	return strings.Contains(s, "unknown command") || strings.Contains(s, "unsupported") || strings.Contains(s, "not supported")
+}
+
+// parseInfoRole gets the role from INFO replication, the slaves are like
+// slave0:ip=127.0.0.1,port=6380,state=online,offset=100,lag=0
+// or slave0:127.0.0.1,6380,online before redis 2.8, without offset.
+func parseInfoRole(m map[string]string) *RoleInfo {
+	r := new(RoleInfo)
+	r.Type = m["role"]
+	if r.Type != MasterType {
+		return r
+	}
+
+	r.Offset, _ = strconv.ParseInt(m["master_repl_offset"], 10, 64)
+
+	for i := 0; ; i++ {
+		v, ok := m[fmt.Sprintf("slave%d", i)]
+		if !ok {
+			break
+		}
+
+		var n NodeStatus
+		if strings.Contains(v, "=") {
+			kv := make(map[string]string)
+			for _, s := range strings.Split(v, ",") {
+				if p := strings.SplitN(s, "=", 2); len(p) == 2 {
+					kv[p[0]] = p[1]
+				}
+			}
+
+			n.Addr = fmt.Sprintf("%s:%s", kv["ip"], kv["port"])
+			n.Offset, _ = strconv.ParseInt(kv["offset"], 10, 64)
+		} else {
+			seps := strings.Split(v, ",")
+			if len(seps) < 2 {
+				continue
+			}
+			n.Addr = fmt.Sprintf("%s:%s", seps[0], seps[1])
+		}
+
+		r.Slaves = append(r.Slaves, n)
+	}
+
+	return r
+}
+
+func (redisBackend) ReplInfo(do doFunc) (*ReplInfo, error) {
+	m, err := doInfo(do, "REPLICATION")
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(ReplInfo)
+	r.Role = m["role"]
+	r.MasterHost = m["master_host"]
+	r.MasterPort = m["master_port"]
+	r.LinkUp = m["master_link_status"] == "up"
+	r.Syncing = m["master_sync_in_progress"] == "1"
+	r.Offset, _ = strconv.ParseInt(m["slave_repl_offset"], 10, 64)
+	r.Priority, _ = strconv.Atoi(m["slave_priority"])
+
+	r.LastIOSeconds = -1
+	if v, ok := m["master_last_io_seconds_ago"]; ok {
+		r.LastIOSeconds, _ = strconv.Atoi(v)
+	}
+
+	return r, nil
+}
+
+func (b redisBackend) SlaveOf(do doFunc, host string, port string) error {
+	cmd, _ := b.replicaOfCommand(do)
+	_, err := do(cmd, host, port)
+	return err
+}
+
+// replicaOfCommand detects the command with INFO SERVER, returns SLAVEOF and the error if failed.
+func (redisBackend) replicaOfCommand(do doFunc) (string, error) {
+	m, err := doInfo(do, "SERVER")
+	if err != nil {
+		return "SLAVEOF", err
+	}
+	return replicaOfCommand(m["redis_version"]), nil
+}
+
+// replicaOfCommand returns REPLICAOF for redis 5.0 and later, SLAVEOF is deprecated there.
+func replicaOfCommand(version string) string {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil || major < 5 {
+		return "SLAVEOF"
+	}
+	return "REPLICAOF"
+}
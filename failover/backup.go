@@ -0,0 +1,252 @@
+package failover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// the version of the backup file
+const backupVersion = 1
+
+// Backup is the portable copy of the cluster state, it can seed a new raft cluster.
+type Backup struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// the raft log index the state has applied, 0 if unknown
+	Index uint64   `json:"index"`
+	Peers []string `json:"peers,omitempty"`
+	// the versioned raft snapshot of the masters, options, maintenance and slaves
+	State json.RawMessage `json:"state"`
+}
+
+func newBackup(fsm *masterFSM, index uint64, peers []string) (*Backup, error) {
+	state, err := encodeSnapshot(fsm.snapshot())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backup{Version: backupVersion, Time: time.Now(), Index: index, Peers: peers, State: state}, nil
+}
+
+// Masters returns the monitored masters in the backup.
+func (b *Backup) Masters() ([]string, error) {
+	s, err := decodeSnapshot(b.State)
+	if err != nil {
+		return nil, err
+	}
+	return s.Masters, nil
+}
+
+// ReadBackupFile reads the backup and checks the state can be restored.
+func ReadBackupFile(name string) (*Backup, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Backup)
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if b.Version > backupVersion || b.Version <= 0 {
+		return nil, fmt.Errorf("%s: backup version %d is not supported", name, b.Version)
+	}
+
+	if _, err := decodeSnapshot(b.State); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+// Backup returns the state of the node with the consistency like GetMasters.
+func (a *App) Backup(consistency string) (*Backup, error) {
+	if err := a.readBarrier(consistency); err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	var index uint64
+	if r, ok := a.cluster.(*Raft); ok && r != nil {
+		var err error
+		if peers, err = r.GetPeers(); err != nil {
+			return nil, err
+		}
+		index = r.r.AppliedIndex()
+	}
+
+	return newBackup(a.masters, index, peers)
+}
+
+// Snapshot lets raft take a snapshot now and compact the log.
+func (r *Raft) Snapshot() error {
+	return r.r.Snapshot().Error()
+}
+
+// ReadDataDir reads the state from the raft data dir of a stopped node, it restores
+// the latest snapshot and replays the logs after it. The logs at the tail may be
+// uncommitted, so read the data dir of the node with the most logs, or use the backup
+// of a running leader if possible.
+func ReadDataDir(dataDir string) (*Backup, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, err
+	}
+
+	snaps, err := raft.NewFileSnapshotStore(dataDir, 1, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	fsm := newMasterFSM()
+
+	var index uint64
+	metas, err := snaps.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) > 0 {
+		meta, rc, err := snaps.Open(metas[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := fsm.Restore(rc); err != nil {
+			return nil, fmt.Errorf("restore snapshot %s err %v", meta.ID, err)
+		}
+		index = meta.Index
+	}
+
+	// the restored data dir has no logs before starting
+	dbPath := path.Join(dataDir, "raft_db")
+	if _, err := os.Stat(dbPath); err == nil {
+		if index, err = replayLogs(dbPath, fsm, index); err != nil {
+			return nil, err
+		}
+	}
+
+	_, trans := raft.NewInmemTransport("")
+	peers, err := raft.NewJSONPeers(dataDir, trans).Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	return newBackup(fsm, index, peers)
+}
+
+// replayLogs applies the logs after index, returns the last log index.
+func replayLogs(dbPath string, fsm *masterFSM, index uint64) (uint64, error) {
+	// raft-boltdb waits for the lock forever if the node is still running
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("open %s err %v, stop redis-failover first", dbPath, err)
+	}
+	db.Close()
+
+	store, err := raftboltdb.NewBoltStore(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		return 0, err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	if first > index+1 && last > 0 {
+		return 0, fmt.Errorf("the logs from %d to %d are lost, the first log is %d", index+1, first-1, first)
+	}
+
+	for i := index + 1; i <= last; i++ {
+		var l raft.Log
+		if err := store.GetLog(i, &l); err != nil {
+			return 0, fmt.Errorf("get log %d err %v", i, err)
+		}
+
+		if l.Type == raft.LogCommand {
+			if err, ok := fsm.Apply(&l).(error); ok {
+				return 0, fmt.Errorf("apply log %d err %v", i, err)
+			}
+		}
+		index = i
+	}
+
+	return index, nil
+}
+
+// RestoreDataDir seeds the empty raft data dir with the backup and peers, run it for
+// every node of the new cluster with the same backup and peers, then start them.
+// If force, the old raft logs, snapshots and peers in the data dir are removed.
+func RestoreDataDir(dataDir string, b *Backup, peers []string, force bool) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("no raft peers")
+	}
+
+	old := []string{path.Join(dataDir, "raft_db"), path.Join(dataDir, "snapshots"), path.Join(dataDir, "peers.json")}
+	for _, name := range old {
+		if _, err := os.Stat(name); err == nil {
+			if !force {
+				return fmt.Errorf("%s exists, the data dir must be empty, or use force to remove it", name)
+			}
+			if err := os.RemoveAll(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	snaps, err := raft.NewFileSnapshotStore(dataDir, 1, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+
+	encPeers := make([][]byte, 0, len(peers))
+	for _, p := range peers {
+		encPeers = append(encPeers, []byte(p))
+	}
+
+	// the same as raft encodes the peers in the snapshot
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf, &codec.MsgpackHandle{}).Encode(encPeers); err != nil {
+		return err
+	}
+
+	// the logs of the new cluster start after the snapshot
+	index := b.Index
+	if index == 0 {
+		index = 1
+	}
+
+	sink, err := snaps.Create(index, 1, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if _, err := sink.Write(b.State); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	if err := sink.Close(); err != nil {
+		return err
+	}
+
+	_, trans := raft.NewInmemTransport("")
+	return raft.NewJSONPeers(dataDir, trans).SetPeers(peers)
+}
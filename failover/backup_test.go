@@ -0,0 +1,113 @@
+package failover
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type backupTestSuite struct {
+}
+
+var _ = Suite(&backupTestSuite{})
+
+func freeAddr(c *C) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startRaft starts a single node raft and waits it to be the leader.
+func startRaft(c *C, dataDir string, addr string) (*Raft, *masterFSM) {
+	cfg := new(Config)
+	cfg.Raft.Addr = addr
+	cfg.Raft.DataDir = dataDir
+	cfg.Raft.LogDir = dataDir
+	cfg.Raft.Cluster = []string{addr}
+	cfg.Raft.ClusterState = ClusterStateExisting
+
+	fsm := newMasterFSM()
+	cluster, err := newRaft(cfg, fsm)
+	c.Assert(err, IsNil)
+
+	r := cluster.(*Raft)
+	for i := 0; i < 100 && !r.IsLeader(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(r.IsLeader(), Equals, true)
+	return r, fsm
+}
+
+func (s *backupTestSuite) TestBackupRestore(c *C) {
+	dir, err := ioutil.TempDir("", "backup")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	oldDir, newDir := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	timeout := 5 * time.Second
+
+	r, _ := startRaft(c, oldDir, freeAddr(c))
+	c.Assert(r.AddMasters([]string{"127.0.0.1:6379", "127.0.0.1:6380"}, timeout), IsNil)
+	c.Assert(r.SetGroupOptions([]string{"127.0.0.1:6379"}, &GroupOptions{MaxDownTime: 5}, timeout), IsNil)
+	c.Assert(r.Snapshot(), IsNil)
+
+	// the logs after the snapshot
+	c.Assert(r.AddMasters([]string{"127.0.0.1:6381"}, timeout), IsNil)
+	c.Assert(r.DelMasters([]string{"127.0.0.1:6380"}, timeout), IsNil)
+	c.Assert(r.SetSlaves("127.0.0.1:6381", []string{"127.0.0.1:6382"}, timeout), IsNil)
+	r.Close()
+
+	b, err := ReadDataDir(oldDir)
+	c.Assert(err, IsNil)
+	masters, err := b.Masters()
+	c.Assert(err, IsNil)
+	c.Assert(masters, DeepEquals, []string{"127.0.0.1:6379", "127.0.0.1:6381"})
+	c.Assert(b.Peers, HasLen, 1)
+	c.Assert(b.Index > 0, Equals, true)
+
+	name := filepath.Join(dir, "backup.json")
+	data, err := json.Marshal(b)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(name, data, 0644), IsNil)
+
+	b, err = ReadBackupFile(name)
+	c.Assert(err, IsNil)
+
+	// the new cluster on the other address
+	addr := freeAddr(c)
+	c.Assert(RestoreDataDir(newDir, b, []string{addr}, false), IsNil)
+	c.Assert(RestoreDataDir(newDir, b, []string{addr}, false), NotNil)
+
+	r, fsm := startRaft(c, newDir, addr)
+	defer r.Close()
+
+	masters = fsm.GetMasters()
+	sort.Strings(masters)
+	c.Assert(masters, DeepEquals, []string{"127.0.0.1:6379", "127.0.0.1:6381"})
+	c.Assert(fsm.GetOptions("127.0.0.1:6379"), Equals, GroupOptions{MaxDownTime: 5})
+	c.Assert(fsm.GetSlaves("127.0.0.1:6381"), DeepEquals, []string{"127.0.0.1:6382"})
+
+	peers, err := r.GetPeers()
+	c.Assert(err, IsNil)
+	c.Assert(peers, DeepEquals, []string{addr})
+
+	// the new logs follow the restored snapshot
+	c.Assert(r.AddMasters([]string{"127.0.0.1:6383"}, timeout), IsNil)
+	c.Assert(fsm.IsMaster("127.0.0.1:6383"), Equals, true)
+
+	a := newDiscoveryApp(&Config{})
+	a.masters = fsm
+	a.cluster = r
+	b, err = a.Backup(ConsistencyLinearizable)
+	c.Assert(err, IsNil)
+	masters, _ = b.Masters()
+	c.Assert(masters, HasLen, 3)
+	c.Assert(b.Peers, DeepEquals, []string{addr})
+}
@@ -1,6 +1,7 @@
 package failover
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -8,10 +9,16 @@ import (
 type Cluster interface {
 	Close()
 	AddMasters(addrs []string, timeout time.Duration) error
+	// AddMastersWithOptions adds the masters and sets the options for the new ones in one command
+	AddMastersWithOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error
 	DelMasters(addrs []string, timeout time.Duration) error
 	SetMasters(addrs []string, timeout time.Duration) error
+	SetGroupOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error
+	SetMaintenance(addrs []string, m *Maintenance, timeout time.Duration) error
+	SetSlaves(master string, slaves []string, timeout time.Duration) error
 	Barrier(timeout time.Duration) error
 	IsLeader() bool
+	Leader() string
 	LeaderCh() <-chan bool
 }
 
@@ -20,38 +27,79 @@ type masterFSM struct {
 	sync.Mutex
 
 	masters map[string]struct{}
+
+	// per group options, key is the master address
+	options map[string]GroupOptions
+
+	// groups in maintenance, key is the master address
+	maintenance map[string]Maintenance
+
+	// the known slaves, key is the master address
+	slaves map[string][]string
+
+	// called after masters changed, outside the lock
+	onChange func(added []string, removed []string)
+
+	// called after a master enters or leaves maintenance, outside the lock
+	onMaintenance func(master string, m *Maintenance)
 }
 
 func newMasterFSM() *masterFSM {
 	fsm := new(masterFSM)
 	fsm.masters = make(map[string]struct{})
+	fsm.options = make(map[string]GroupOptions)
+	fsm.maintenance = make(map[string]Maintenance)
+	fsm.slaves = make(map[string][]string)
 	return fsm
 }
 
 func (fsm *masterFSM) AddMasters(addrs []string) {
-	fsm.Lock()
-	defer fsm.Unlock()
+	fsm.AddMastersWithOptions(addrs, nil)
+}
+
+// AddMastersWithOptions adds the masters, the new ones get the options if not nil,
+// so they are never checked without the options.
+func (fsm *masterFSM) AddMastersWithOptions(addrs []string, opts *GroupOptions) {
+	var added []string
 
+	fsm.Lock()
 	for _, addr := range addrs {
 		if len(addr) == 0 {
 			continue
 		}
+		if _, ok := fsm.masters[addr]; !ok {
+			added = append(added, addr)
+			if opts != nil {
+				fsm.options[addr] = opts.withoutHooks()
+			}
+		}
 		fsm.masters[addr] = struct{}{}
 	}
+	fsm.Unlock()
 
+	fsm.notify(added, nil)
 }
 
 func (fsm *masterFSM) DelMasters(addrs []string) {
-	fsm.Lock()
-	defer fsm.Unlock()
+	var removed []string
 
+	fsm.Lock()
 	for _, addr := range addrs {
 		if len(addr) == 0 {
 			continue
 		}
 
+		if _, ok := fsm.masters[addr]; ok {
+			removed = append(removed, addr)
+		}
 		delete(fsm.masters, addr)
+		delete(fsm.options, addr)
+		delete(fsm.maintenance, addr)
+		delete(fsm.slaves, addr)
 	}
+	fsm.Unlock()
+
+	fsm.notify(nil, removed)
 }
 
 func (fsm *masterFSM) SetMasters(addrs []string) {
@@ -65,10 +113,34 @@ func (fsm *masterFSM) SetMasters(addrs []string) {
 		m[addr] = struct{}{}
 	}
 
-	fsm.Lock()
-	defer fsm.Unlock()
+	var added, removed []string
 
+	fsm.Lock()
+	for addr := range m {
+		if _, ok := fsm.masters[addr]; !ok {
+			added = append(added, addr)
+		}
+	}
+	for addr := range fsm.masters {
+		if _, ok := m[addr]; !ok {
+			removed = append(removed, addr)
+			delete(fsm.options, addr)
+			delete(fsm.maintenance, addr)
+			delete(fsm.slaves, addr)
+		}
+	}
 	fsm.masters = m
+	fsm.Unlock()
+
+	fsm.notify(added, removed)
+}
+
+func (fsm *masterFSM) notify(added []string, removed []string) {
+	if fsm.onChange == nil || (len(added) == 0 && len(removed) == 0) {
+		return
+	}
+
+	fsm.onChange(added, removed)
 }
 
 func (fsm *masterFSM) GetMasters() []string {
@@ -91,6 +163,162 @@ func (fsm *masterFSM) IsMaster(addr string) bool {
 	return ok
 }
 
+// SetOptions sets the options for the monitored masters, nil means removing the options.
+func (fsm *masterFSM) SetOptions(addrs []string, opts *GroupOptions) {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	for _, addr := range addrs {
+		if _, ok := fsm.masters[addr]; !ok {
+			continue
+		}
+
+		if opts == nil {
+			delete(fsm.options, addr)
+		} else {
+			fsm.options[addr] = opts.withoutHooks()
+		}
+	}
+}
+
+func (fsm *masterFSM) GetOptions(addr string) GroupOptions {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	return fsm.options[addr]
+}
+
+func (fsm *masterFSM) GetAllOptions() map[string]GroupOptions {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	m := make(map[string]GroupOptions, len(fsm.options))
+	for addr, opts := range fsm.options {
+		m[addr] = opts
+	}
+	return m
+}
+
+// ReplaceOptions replaces all the options.
+func (fsm *masterFSM) ReplaceOptions(m map[string]GroupOptions) {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	fsm.options = make(map[string]GroupOptions, len(m))
+	for addr, opts := range m {
+		fsm.options[addr] = opts.withoutHooks()
+	}
+}
+
+// SetMaintenance lets the monitored masters enter maintenance, nil means leaving maintenance.
+func (fsm *masterFSM) SetMaintenance(addrs []string, m *Maintenance) {
+	var changed []string
+
+	fsm.Lock()
+	for _, addr := range addrs {
+		if _, ok := fsm.masters[addr]; !ok {
+			continue
+		}
+
+		_, ok := fsm.maintenance[addr]
+		if m == nil {
+			if ok {
+				changed = append(changed, addr)
+			}
+			delete(fsm.maintenance, addr)
+		} else {
+			changed = append(changed, addr)
+			fsm.maintenance[addr] = *m
+		}
+	}
+	fsm.Unlock()
+
+	if fsm.onMaintenance != nil {
+		for _, addr := range changed {
+			fsm.onMaintenance(addr, m)
+		}
+	}
+}
+
+// GetMaintenance returns nil if the master is not in maintenance.
+func (fsm *masterFSM) GetMaintenance(addr string) *Maintenance {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	m, ok := fsm.maintenance[addr]
+	if !ok {
+		return nil
+	}
+	return &m
+}
+
+func (fsm *masterFSM) GetAllMaintenance() map[string]Maintenance {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	m := make(map[string]Maintenance, len(fsm.maintenance))
+	for addr, v := range fsm.maintenance {
+		m[addr] = v
+	}
+	return m
+}
+
+// ReplaceMaintenance replaces all the maintenance.
+func (fsm *masterFSM) ReplaceMaintenance(m map[string]Maintenance) {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	fsm.maintenance = make(map[string]Maintenance, len(m))
+	for addr, v := range m {
+		fsm.maintenance[addr] = v
+	}
+}
+
+// SetSlaves sets the known slaves of the monitored master, empty means removing them.
+func (fsm *masterFSM) SetSlaves(master string, slaves []string) {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	if _, ok := fsm.masters[master]; !ok {
+		return
+	}
+
+	if len(slaves) == 0 {
+		delete(fsm.slaves, master)
+	} else {
+		fsm.slaves[master] = append([]string(nil), slaves...)
+	}
+}
+
+func (fsm *masterFSM) GetSlaves(master string) []string {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	return append([]string(nil), fsm.slaves[master]...)
+}
+
+func (fsm *masterFSM) GetAllSlaves() map[string][]string {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	m := make(map[string][]string, len(fsm.slaves))
+	for master, slaves := range fsm.slaves {
+		m[master] = append([]string(nil), slaves...)
+	}
+	return m
+}
+
+// ReplaceSlaves replaces all the known slaves.
+func (fsm *masterFSM) ReplaceSlaves(m map[string][]string) {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	fsm.slaves = make(map[string][]string, len(m))
+	for master, slaves := range m {
+		fsm.slaves[master] = append([]string(nil), slaves...)
+	}
+}
+
 func (fsm *masterFSM) Copy() *masterFSM {
 	fsm.Lock()
 	defer fsm.Unlock()
@@ -102,27 +330,162 @@ func (fsm *masterFSM) Copy() *masterFSM {
 		o.masters[master] = struct{}{}
 	}
 
+	o.options = make(map[string]GroupOptions, len(fsm.options))
+	for master, opts := range fsm.options {
+		o.options[master] = opts
+	}
+
+	o.maintenance = make(map[string]Maintenance, len(fsm.maintenance))
+	for master, m := range fsm.maintenance {
+		o.maintenance[master] = m
+	}
+
+	o.slaves = make(map[string][]string, len(fsm.slaves))
+	for master, slaves := range fsm.slaves {
+		o.slaves[master] = append([]string(nil), slaves...)
+	}
+
 	return o
 }
 
+// snapshot returns the state in one lock.
+func (fsm *masterFSM) snapshot() *masterSnapshot {
+	fsm.Lock()
+	defer fsm.Unlock()
+
+	s := new(masterSnapshot)
+	s.Masters = make([]string, 0, len(fsm.masters))
+	for master := range fsm.masters {
+		s.Masters = append(s.Masters, master)
+	}
+	sort.Strings(s.Masters)
+
+	s.Options = make(map[string]GroupOptions, len(fsm.options))
+	for master, opts := range fsm.options {
+		s.Options[master] = opts
+	}
+
+	s.Maintenance = make(map[string]Maintenance, len(fsm.maintenance))
+	for master, m := range fsm.maintenance {
+		s.Maintenance[master] = m
+	}
+
+	s.Slaves = make(map[string][]string, len(fsm.slaves))
+	for master, slaves := range fsm.slaves {
+		s.Slaves[master] = append([]string(nil), slaves...)
+	}
+
+	return s
+}
+
+// restore replaces the state with the snapshot, the options, maintenance
+// and slaves of the masters not in the snapshot are dropped.
+func (fsm *masterFSM) restore(s *masterSnapshot) {
+	masters := make(map[string]struct{}, len(s.Masters))
+	for _, master := range s.Masters {
+		if len(master) > 0 {
+			masters[master] = struct{}{}
+		}
+	}
+
+	options := make(map[string]GroupOptions, len(s.Options))
+	for master, opts := range s.Options {
+		if _, ok := masters[master]; ok {
+			options[master] = opts.withoutHooks()
+		}
+	}
+
+	maintenance := make(map[string]Maintenance, len(s.Maintenance))
+	for master, m := range s.Maintenance {
+		if _, ok := masters[master]; ok {
+			maintenance[master] = m
+		}
+	}
+
+	slaves := make(map[string][]string, len(s.Slaves))
+	for master, ss := range s.Slaves {
+		if _, ok := masters[master]; ok && len(ss) > 0 {
+			slaves[master] = append([]string(nil), ss...)
+		}
+	}
+
+	var added, removed []string
+	var entered, left []string
+
+	fsm.Lock()
+	for master := range masters {
+		if _, ok := fsm.masters[master]; !ok {
+			added = append(added, master)
+		}
+	}
+	for master := range fsm.masters {
+		if _, ok := masters[master]; !ok {
+			removed = append(removed, master)
+		}
+	}
+
+	for master, m := range maintenance {
+		if old, ok := fsm.maintenance[master]; !ok || !old.equal(&m) {
+			entered = append(entered, master)
+		}
+	}
+	for master := range fsm.maintenance {
+		if _, ok := maintenance[master]; !ok {
+			left = append(left, master)
+		}
+	}
+
+	fsm.masters = masters
+	fsm.options = options
+	fsm.maintenance = maintenance
+	fsm.slaves = slaves
+	fsm.Unlock()
+
+	fsm.notify(added, removed)
+
+	if fsm.onMaintenance != nil {
+		for _, master := range entered {
+			m := maintenance[master]
+			fsm.onMaintenance(master, &m)
+		}
+		for _, master := range left {
+			fsm.onMaintenance(master, nil)
+		}
+	}
+}
+
 const (
-	addCmd = "add"
-	delCmd = "del"
-	setCmd = "set"
+	addCmd   = "add"
+	delCmd   = "del"
+	setCmd   = "set"
+	optCmd   = "opt"
+	maintCmd = "maint"
+	slaveCmd = "slave"
 )
 
 type action struct {
-	Cmd     string   `json:"cmd"`
-	Masters []string `json:"masters"`
+	Cmd         string        `json:"cmd"`
+	Masters     []string      `json:"masters"`
+	Options     *GroupOptions `json:"options,omitempty"`
+	Maintenance *Maintenance  `json:"maintenance,omitempty"`
+	Slaves      []string      `json:"slaves,omitempty"`
 }
 
 func (fsm *masterFSM) handleAction(a *action) {
 	switch a.Cmd {
 	case addCmd:
-		fsm.AddMasters(a.Masters)
+		fsm.AddMastersWithOptions(a.Masters, a.Options)
 	case delCmd:
 		fsm.DelMasters(a.Masters)
 	case setCmd:
 		fsm.SetMasters(a.Masters)
+	case optCmd:
+		fsm.SetOptions(a.Masters, a.Options)
+	case maintCmd:
+		fsm.SetMaintenance(a.Masters, a.Maintenance)
+	case slaveCmd:
+		for _, master := range a.Masters {
+			fsm.SetSlaves(master, a.Slaves)
+		}
 	}
 }
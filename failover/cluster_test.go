@@ -0,0 +1,376 @@
+package failover
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+	. "gopkg.in/check.v1"
+)
+
+type fsmTestSuite struct {
+}
+
+var _ = Suite(&fsmTestSuite{})
+
+// fsmModel is the reference model of masterFSM, it is as simple as possible.
+type fsmModel struct {
+	masters     map[string]bool
+	options     map[string]GroupOptions
+	maintenance map[string]Maintenance
+	slaves      map[string][]string
+}
+
+func newFSMModel() *fsmModel {
+	return &fsmModel{
+		masters:     map[string]bool{},
+		options:     map[string]GroupOptions{},
+		maintenance: map[string]Maintenance{},
+		slaves:      map[string][]string{},
+	}
+}
+
+func (m *fsmModel) del(addr string) {
+	delete(m.masters, addr)
+	delete(m.options, addr)
+	delete(m.maintenance, addr)
+	delete(m.slaves, addr)
+}
+
+func (m *fsmModel) apply(a *action) {
+	switch a.Cmd {
+	case addCmd:
+		for _, addr := range a.Masters {
+			m.masters[addr] = true
+		}
+	case delCmd:
+		for _, addr := range a.Masters {
+			m.del(addr)
+		}
+	case setCmd:
+		set := map[string]bool{}
+		for _, addr := range a.Masters {
+			set[addr] = true
+		}
+		for addr := range m.masters {
+			if !set[addr] {
+				m.del(addr)
+			}
+		}
+		m.masters = set
+	case optCmd:
+		for _, addr := range a.Masters {
+			if !m.masters[addr] {
+				continue
+			}
+			if a.Options == nil {
+				delete(m.options, addr)
+			} else {
+				m.options[addr] = *a.Options
+			}
+		}
+	case maintCmd:
+		for _, addr := range a.Masters {
+			if !m.masters[addr] {
+				continue
+			}
+			if a.Maintenance == nil {
+				delete(m.maintenance, addr)
+			} else {
+				m.maintenance[addr] = *a.Maintenance
+			}
+		}
+	case slaveCmd:
+		for _, addr := range a.Masters {
+			if !m.masters[addr] {
+				continue
+			}
+			if len(a.Slaves) == 0 {
+				delete(m.slaves, addr)
+			} else {
+				m.slaves[addr] = a.Slaves
+			}
+		}
+	}
+}
+
+func (m *fsmModel) check(fsm *masterFSM) error {
+	masters := fsm.GetMasters()
+	sort.Strings(masters)
+
+	var want []string
+	for addr := range m.masters {
+		want = append(want, addr)
+	}
+	sort.Strings(want)
+
+	switch {
+	case !reflect.DeepEqual(masters, want) && (len(masters) > 0 || len(want) > 0):
+		return fmt.Errorf("masters %v, want %v", masters, want)
+	case !reflect.DeepEqual(fsm.GetAllOptions(), m.options):
+		return fmt.Errorf("options %v, want %v", fsm.GetAllOptions(), m.options)
+	case !reflect.DeepEqual(fsm.GetAllSlaves(), m.slaves):
+		return fmt.Errorf("slaves %v, want %v", fsm.GetAllSlaves(), m.slaves)
+	}
+
+	got := fsm.GetAllMaintenance()
+	if len(got) != len(m.maintenance) {
+		return fmt.Errorf("maintenance %v, want %v", got, m.maintenance)
+	}
+	for addr, v := range m.maintenance {
+		if g, ok := got[addr]; !ok || !g.equal(&v) {
+			return fmt.Errorf("maintenance %v, want %v", got, m.maintenance)
+		}
+	}
+	return nil
+}
+
+func randAddrs(r *rand.Rand) []string {
+	addrs := make([]string, r.Intn(4))
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", 6379+r.Intn(8))
+	}
+	return addrs
+}
+
+func randAction(r *rand.Rand) *action {
+	a := &action{Masters: randAddrs(r)}
+	switch r.Intn(8) {
+	case 0, 1:
+		a.Cmd = addCmd
+	case 2:
+		a.Cmd = delCmd
+	case 3:
+		a.Cmd = setCmd
+	case 4:
+		a.Cmd = optCmd
+		if r.Intn(3) > 0 {
+			a.Options = &GroupOptions{MaxDownTime: r.Intn(10), ElectStrategy: ElectByOffset}
+		}
+	case 5:
+		a.Cmd = maintCmd
+		if r.Intn(3) > 0 {
+			a.Maintenance = &Maintenance{Since: time.Unix(r.Int63n(1e9), 0), Reason: "test"}
+		}
+	default:
+		a.Cmd = slaveCmd
+		if len(a.Masters) > 1 {
+			a.Masters = a.Masters[:1]
+		}
+		a.Slaves = randAddrs(r)
+	}
+	return a
+}
+
+func applyRaft(fsm *masterFSM, a *action) error {
+	data, err := encodeCommand(a)
+	if err != nil {
+		return err
+	}
+
+	if err, ok := fsm.Apply(&raft.Log{Data: data}).(error); ok {
+		return err
+	}
+	return nil
+}
+
+func persistSnapshot(fsm *masterFSM) ([]byte, error) {
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	sink := new(bufferSink)
+	err = snap.Persist(sink)
+	snap.Release()
+	return sink.Bytes(), err
+}
+
+// TestSnapshotRestore applies random commands to the leader and a follower,
+// the follower restores the leader's snapshot at random points, after that
+// both must be the same as the reference model, even if the follower had
+// diverged before restoring.
+func (s *fsmTestSuite) TestSnapshotRestore(c *C) {
+	for seed := int64(0); seed < 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+
+		model := newFSMModel()
+		leader := newMasterFSM()
+		follower := newMasterFSM()
+
+		for i := 0; i < 100; i++ {
+			a := randAction(r)
+			model.apply(a)
+			c.Assert(applyRaft(leader, a), IsNil)
+
+			if err := model.check(leader); err != nil {
+				c.Fatalf("seed %d step %d leader after %s %v: %v", seed, i, a.Cmd, a.Masters, err)
+			}
+
+			switch r.Intn(10) {
+			case 0:
+				// the follower misses some logs and has the stale state
+				c.Assert(applyRaft(follower, randAction(r)), IsNil)
+			case 1:
+				data, err := persistSnapshot(leader)
+				c.Assert(err, IsNil)
+				c.Assert(follower.Restore(ioutil.NopCloser(bytes.NewReader(data))), IsNil)
+
+				if err := model.check(follower); err != nil {
+					c.Fatalf("seed %d step %d follower after restore: %v", seed, i, err)
+				}
+			default:
+				c.Assert(applyRaft(follower, a), IsNil)
+			}
+		}
+	}
+}
+
+func (s *fsmTestSuite) TestRestoreNotify(c *C) {
+	fsm := newMasterFSM()
+
+	var added, removed, maint []string
+	fsm.onChange = func(a []string, r []string) {
+		added = append(added, a...)
+		removed = append(removed, r...)
+	}
+	fsm.onMaintenance = func(master string, m *Maintenance) {
+		maint = append(maint, fmt.Sprintf("%s %v", master, m != nil))
+	}
+
+	fsm.AddMasters([]string{"127.0.0.1:6379", "127.0.0.1:6380"})
+	fsm.SetMaintenance([]string{"127.0.0.1:6380"}, &Maintenance{Since: time.Unix(100, 0)})
+	added, maint = nil, nil
+
+	fsm.restore(&masterSnapshot{
+		Masters:     []string{"127.0.0.1:6379", "127.0.0.1:6381"},
+		Options:     map[string]GroupOptions{"127.0.0.1:6380": {MaxDownTime: 3}},
+		Maintenance: map[string]Maintenance{"127.0.0.1:6381": {Since: time.Unix(100, 0)}},
+	})
+
+	c.Assert(added, DeepEquals, []string{"127.0.0.1:6381"})
+	c.Assert(removed, DeepEquals, []string{"127.0.0.1:6380"})
+	c.Assert(maint, DeepEquals, []string{"127.0.0.1:6381 true", "127.0.0.1:6380 false"})
+	// the options of the removed master are dropped
+	c.Assert(fsm.GetAllOptions(), HasLen, 0)
+}
+
+// fakeCluster applies the writes to the local FSM directly.
+type fakeCluster struct {
+	fsm        *masterFSM
+	leader     bool
+	barrierErr error
+	barriers   int
+	// the number of SetSlaves
+	slaveWrites int
+	// the number of SetGroupOptions
+	optionWrites int
+}
+
+func (f *fakeCluster) Close() {}
+
+func (f *fakeCluster) AddMasters(addrs []string, timeout time.Duration) error {
+	f.fsm.AddMasters(addrs)
+	return nil
+}
+
+func (f *fakeCluster) AddMastersWithOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
+	f.fsm.AddMastersWithOptions(addrs, opts)
+	return nil
+}
+
+func (f *fakeCluster) DelMasters(addrs []string, timeout time.Duration) error {
+	f.fsm.DelMasters(addrs)
+	return nil
+}
+
+func (f *fakeCluster) SetMasters(addrs []string, timeout time.Duration) error {
+	f.fsm.SetMasters(addrs)
+	return nil
+}
+
+func (f *fakeCluster) SetGroupOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
+	f.optionWrites++
+	f.fsm.SetOptions(addrs, opts)
+	return nil
+}
+
+func (f *fakeCluster) SetMaintenance(addrs []string, m *Maintenance, timeout time.Duration) error {
+	f.fsm.SetMaintenance(addrs, m)
+	return nil
+}
+
+func (f *fakeCluster) SetSlaves(master string, slaves []string, timeout time.Duration) error {
+	f.slaveWrites++
+	f.fsm.SetSlaves(master, slaves)
+	return nil
+}
+
+func (f *fakeCluster) Barrier(timeout time.Duration) error {
+	f.barriers++
+	if f.barrierErr != nil {
+		// the barrier fails if the leadership is lost
+		f.leader = false
+	}
+	return f.barrierErr
+}
+
+func (f *fakeCluster) IsLeader() bool        { return f.leader }
+func (f *fakeCluster) Leader() string        { return "" }
+func (f *fakeCluster) LeaderCh() <-chan bool { return nil }
+
+func (s *fsmTestSuite) TestReadConsistency(c *C) {
+	a := newDiscoveryApp(&Config{})
+	a.masters.AddMasters([]string{"127.0.0.1:6380", "127.0.0.1:6379"})
+
+	// no cluster, all reads are local
+	masters, err := a.GetMasters(ConsistencyLinearizable)
+	c.Assert(err, IsNil)
+	c.Assert(masters, DeepEquals, []string{"127.0.0.1:6379", "127.0.0.1:6380"})
+
+	f := &fakeCluster{fsm: a.masters}
+	a.cluster = f
+
+	// the follower only serves the stale read
+	_, err = a.GetMasters(ConsistencyStale)
+	c.Assert(err, IsNil)
+	_, err = a.GetMasters(ConsistencyLeader)
+	c.Assert(err, Equals, ErrNotLeader)
+	_, err = a.GetMasters(ConsistencyLinearizable)
+	c.Assert(err, Equals, ErrNotLeader)
+	c.Assert(f.barriers, Equals, 0)
+
+	f.leader = true
+	_, err = a.GetMasters(ConsistencyLeader)
+	c.Assert(err, IsNil)
+	c.Assert(f.barriers, Equals, 0)
+	_, err = a.GetMasters(ConsistencyLinearizable)
+	c.Assert(err, IsNil)
+	c.Assert(f.barriers, Equals, 1)
+
+	_, err = a.GetMasters("strong")
+	c.Assert(err, NotNil)
+
+	h := &masterHandler{a}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/master?consistency=linearizable", nil))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, "127.0.0.1:6379,127.0.0.1:6380")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/master?consistency=strong", nil))
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+
+	// the deposed leader doesn't know it until the barrier fails
+	f.barrierErr = raft.ErrLeadershipLost
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/master?consistency=linearizable", nil))
+	c.Assert(w.Code, Equals, http.StatusServiceUnavailable)
+}
@@ -1,7 +1,13 @@
 package failover
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -29,16 +35,121 @@ type ZkConfig struct {
 	BaseDir string   `toml:"base_dir"`
 }
 
+type RedisClusterConfig struct {
+	// Find the shard masters from any of the seeds with CLUSTER NODES
+	Seeds []string `toml:"seeds"`
+	// Find the new shard masters every n seconds
+	RefreshInterval int `toml:"refresh_interval"`
+}
+
+type SourceConfig struct {
+	// file, dns_srv, http or kubernetes
+	Type string `toml:"type"`
+	// The toml file or directory of toml files with [[groups]] for file
+	Path string `toml:"path"`
+	// The SRV record name for dns_srv
+	Name string `toml:"name"`
+	// The URL returning the JSON array of groups for http
+	URL string `toml:"url"`
+}
+
+type KubernetesConfig struct {
+	// The API server like https://127.0.0.1:6443, the service account is used if empty in the pod
+	APIServer string `toml:"api_server"`
+	// The bearer token and CA certificate files
+	TokenFile string `toml:"token_file"`
+	CAFile    string `toml:"ca_file"`
+
+	Namespace string `toml:"namespace"`
+	// The label selector of the redis pods, like app=redis
+	Selector string `toml:"selector"`
+	// The pods with the same value of the label are a group, default is redis-failover/group
+	GroupLabel string `toml:"group_label"`
+	// The redis port of the pods, default is 6379
+	Port int `toml:"port"`
+	// If set, the Endpoints <endpoints_prefix><group> points at the master of the group
+	EndpointsPrefix string `toml:"endpoints_prefix"`
+}
+
+type DiscoveryConfig struct {
+	// Reconcile the monitored masters with the sources every n seconds
+	Interval int `toml:"interval"`
+	// Refuse to remove more than n percent of the monitored masters in one reconcile unless forced
+	MaxRemovalPercent int `toml:"max_removal_percent"`
+
+	Sources []SourceConfig `toml:"sources"`
+}
+
+type HooksConfig struct {
+	// Shell command run before failover, the down master is the argument
+	BeforeFailover string `toml:"before_failover" json:"before_failover,omitempty"`
+	// Shell command run after failover, the down and new master are the arguments
+	AfterFailover string `toml:"after_failover" json:"after_failover,omitempty"`
+	// Timeout in seconds for running a hook
+	Timeout int `toml:"timeout" json:"timeout,omitempty"`
+}
+
 type Config struct {
 	Addr          string   `toml:"addr"`
 	Masters       []string `toml:"masters"`
 	MastersState  string   `toml:"masters_state"`
 	CheckInterval int      `toml:"check_interval"`
 	MaxDownTime   int      `toml:"max_down_time"`
+	LogLevel      string   `toml:"log_level"`
+
+	// The server type of the masters, redis or ledis, the groups can override it
+	Backend string `toml:"backend"`
+
+	// If true, we only elect the candidate and report it for a down master, never do failover
+	ObserveOnly bool `toml:"observe_only"`
+
+	// Forbid the failover for the new and old master in n seconds after a failover
+	FailoverCooldown int `toml:"failover_cooldown"`
+	// Max failovers started in one check, 0 means no limit
+	MaxConcurrentFailover int `toml:"max_concurrent_failover"`
+	// If more than n groups are down at the same time, pause failover, 0 means no limit
+	MaxDownGroups int `toml:"max_down_groups"`
+
+	// Wait the candidate to catch up with the most up-to-date slave at most n seconds before promoting, 0 means no waiting
+	CatchUpTimeout int `toml:"catch_up_timeout"`
+	// Refuse to promote the candidate if it is behind more than n bytes, unless forced, 0 means no limit
+	MaxDataLoss int64 `toml:"max_data_loss"`
+	// Verify the replication topology at most n seconds after failover
+	VerifyTimeout int `toml:"verify_timeout"`
+	// If true, CONFIG REWRITE every node changed in failover, so the topology survives redis restart
+	ConfigRewrite bool `toml:"config_rewrite"`
+	// none, pause or min_replicas, stop the writes to the old master before promoting
+	Fence string `toml:"fence"`
+	// Fence the old master at most n seconds
+	FenceTimeout int `toml:"fence_timeout"`
+	// The slave lagging more than n bytes is unhealthy and can't be elected, 0 means no limit
+	ReplicaMaxLag int64 `toml:"replica_max_lag"`
+	// The slave not hearing from master more than n seconds is unhealthy and can't be elected, 0 means no limit
+	ReplicaMaxLagTime int `toml:"replica_max_lag_time"`
+
+	// The probes besides ROLE, seperated by comma, like ping,canary,persistence
+	Probes string `toml:"probes"`
+	// Max PING latency in millisecond for the ping probe, 0 means no limit
+	PingMaxLatency int `toml:"ping_max_latency"`
+	// The key for the canary probe
+	CanaryKey string `toml:"canary_key"`
 
 	Broker string     `toml:"broker"`
 	Raft   RaftConfig `toml:"raft"`
 	Zk     ZkConfig   `toml:"zk"`
+
+	Hooks HooksConfig `toml:"hooks"`
+
+	RedisCluster RedisClusterConfig `toml:"redis_cluster"`
+
+	Discovery DiscoveryConfig `toml:"discovery"`
+
+	Kubernetes KubernetesConfig `toml:"kubernetes"`
+
+	Groups []GroupConfig `toml:"groups"`
+
+	// The file the config loaded from
+	FileName string `toml:"-"`
 }
 
 func NewConfigWithFile(name string) (*Config, error) {
@@ -47,16 +158,408 @@ func NewConfigWithFile(name string) (*Config, error) {
 		return nil, err
 	}
 
-	return NewConfig(string(data))
+	c, err := NewConfig(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	c.FileName = name
+	return c, nil
 }
 
 func NewConfig(data string) (*Config, error) {
 	var c Config
 
-	_, err := toml.Decode(data, &c)
+	md, err := toml.Decode(data, &c)
 	if err != nil {
 		return nil, err
 	}
 
+	// the misspelled keys are ignored by toml silently
+	if keys := md.Undecoded(); len(keys) > 0 {
+		errs := make(ConfigError, 0, len(keys))
+		for _, key := range keys {
+			errs = append(errs, fmt.Errorf("%s: unknown key", key))
+		}
+		return nil, errs
+	}
+
 	return &c, nil
 }
+
+func (c *Config) adjust() {
+	if c.MaxDownTime <= 0 {
+		c.MaxDownTime = 3
+	}
+
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = 1000
+	}
+
+	if c.Hooks.Timeout <= 0 {
+		c.Hooks.Timeout = 10
+	}
+
+	if c.VerifyTimeout <= 0 {
+		c.VerifyTimeout = 10
+	}
+
+	if c.FenceTimeout <= 0 {
+		c.FenceTimeout = 10
+	}
+
+	if len(c.CanaryKey) == 0 {
+		c.CanaryKey = defaultCanaryKey
+	}
+
+	if c.RedisCluster.RefreshInterval <= 0 {
+		c.RedisCluster.RefreshInterval = 10
+	}
+
+	if c.Discovery.Interval <= 0 {
+		c.Discovery.Interval = 30
+	}
+
+	if c.Discovery.MaxRemovalPercent <= 0 {
+		c.Discovery.MaxRemovalPercent = 20
+	}
+
+	if len(c.Kubernetes.Namespace) == 0 {
+		c.Kubernetes.Namespace = "default"
+	}
+
+	if len(c.Kubernetes.GroupLabel) == 0 {
+		c.Kubernetes.GroupLabel = defaultKubeGroupLabel
+	}
+
+	if c.Kubernetes.Port == 0 {
+		c.Kubernetes.Port = 6379
+	}
+}
+
+// allMasters returns the masters and the masters in groups.
+func (c *Config) allMasters() []string {
+	masters := make([]string, 0, len(c.Masters)+len(c.Groups))
+	masters = append(masters, c.Masters...)
+	for _, g := range c.Groups {
+		masters = append(masters, g.Master)
+	}
+	return masters
+}
+
+// groupHooks returns the hooks of the group which has any of the addrs as master or slave,
+// so the hooks are still used after the slave in config is promoted.
+func (c *Config) groupHooks(addrs []string) HooksConfig {
+	for _, g := range c.Groups {
+		for _, addr := range addrs {
+			if g.Master == addr {
+				return g.Hooks
+			}
+
+			for _, slave := range g.Slaves {
+				if slave == addr {
+					return g.Hooks
+				}
+			}
+		}
+	}
+	return HooksConfig{}
+}
+
+// restartFields returns the changed fields which can not be applied without restart.
+func (c *Config) restartFields(o *Config) []string {
+	var fields []string
+
+	if c.Addr != o.Addr {
+		fields = append(fields, "addr")
+	}
+
+	if c.Broker != o.Broker {
+		fields = append(fields, "broker")
+	}
+
+	if !reflect.DeepEqual(c.Raft, o.Raft) {
+		fields = append(fields, "raft")
+	}
+
+	if !reflect.DeepEqual(c.Zk, o.Zk) {
+		fields = append(fields, "zk")
+	}
+
+	return fields
+}
+
+// ConfigError contains all the errors found in validating the config.
+type ConfigError []error
+
+func (e ConfigError) Error() string {
+	s := make([]string, 0, len(e))
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return fmt.Sprintf("invalid config: %s", strings.Join(s, "; "))
+}
+
+// Validate checks the config and returns a ConfigError with all found errors.
+func (c *Config) Validate() error {
+	var errs ConfigError
+
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(c.Addr) > 0 {
+		if err := validateAddr(c.Addr); err != nil {
+			add("addr: %v", err)
+		}
+	}
+
+	for _, master := range c.Masters {
+		if err := validateNodeAddr(master); err != nil {
+			add("masters: %v", err)
+		}
+	}
+
+	switch c.MastersState {
+	case "", MastersStateNew, MastersStateExisting:
+	default:
+		add("masters_state: must be %s or %s, not %q", MastersStateNew, MastersStateExisting, c.MastersState)
+	}
+
+	if err := validateBackend(c.Backend); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.CheckInterval < 0 {
+		add("check_interval: must not be negative")
+	}
+
+	if c.MaxDownTime < 0 {
+		add("max_down_time: must not be negative")
+	}
+
+	if c.FailoverCooldown < 0 {
+		add("failover_cooldown: must not be negative")
+	}
+
+	if c.MaxConcurrentFailover < 0 {
+		add("max_concurrent_failover: must not be negative")
+	}
+
+	if c.MaxDownGroups < 0 {
+		add("max_down_groups: must not be negative")
+	}
+
+	if c.CatchUpTimeout < 0 {
+		add("catch_up_timeout: must not be negative")
+	}
+
+	if c.MaxDataLoss < 0 {
+		add("max_data_loss: must not be negative")
+	}
+
+	if c.VerifyTimeout < 0 {
+		add("verify_timeout: must not be negative")
+	}
+
+	if err := validateFence(c.Fence); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.FenceTimeout < 0 {
+		add("fence_timeout: must not be negative")
+	}
+
+	if c.ReplicaMaxLag < 0 {
+		add("replica_max_lag: must not be negative")
+	}
+
+	if c.ReplicaMaxLagTime < 0 {
+		add("replica_max_lag_time: must not be negative")
+	}
+
+	if _, err := parseProbes(c.Probes); err != nil {
+		add("probes: %v", err)
+	}
+
+	if c.PingMaxLatency < 0 {
+		add("ping_max_latency: must not be negative")
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "trace", "debug", "info", "warn", "error", "fatal":
+	default:
+		add("log_level: unknown level %q", c.LogLevel)
+	}
+
+	if c.Hooks.Timeout < 0 {
+		add("hooks.timeout: must not be negative")
+	}
+
+	for _, seed := range c.RedisCluster.Seeds {
+		if err := validateNodeAddr(seed); err != nil {
+			add("redis_cluster.seeds: %v", err)
+		}
+	}
+
+	if c.RedisCluster.RefreshInterval < 0 {
+		add("redis_cluster.refresh_interval: must not be negative")
+	}
+
+	if c.Discovery.Interval < 0 {
+		add("discovery.interval: must not be negative")
+	}
+
+	if c.Discovery.MaxRemovalPercent < 0 || c.Discovery.MaxRemovalPercent > 100 {
+		add("discovery.max_removal_percent: must be in [0, 100]")
+	}
+
+	for i, s := range c.Discovery.Sources {
+		switch {
+		case s.Type == SourceFile && len(s.Path) == 0:
+			add("discovery.sources[%d]: empty path", i)
+		case s.Type == SourceDNSSRV && len(s.Name) == 0:
+			add("discovery.sources[%d]: empty name", i)
+		case s.Type == SourceHTTP && len(s.URL) == 0:
+			add("discovery.sources[%d]: empty url", i)
+		case s.Type == SourceKubernetes:
+			if len(c.Kubernetes.Selector) == 0 {
+				add("discovery.sources[%d]: kubernetes.selector is empty", i)
+			}
+		default:
+			if _, err := newDiscoverySource(s); err != nil {
+				add("discovery.sources[%d]: %v", i, err)
+			}
+		}
+	}
+
+	if c.Kubernetes.Port < 0 || c.Kubernetes.Port > 65535 {
+		add("kubernetes.port: must be in [0, 65535]")
+	}
+
+	if len(c.Kubernetes.EndpointsPrefix) > 0 && len(c.Kubernetes.Selector) == 0 {
+		add("kubernetes.endpoints_prefix: kubernetes.selector is empty")
+	}
+
+	for i, g := range c.Groups {
+		if err := validateNodeAddr(g.Master); err != nil {
+			add("groups[%d].master: %v", i, err)
+		}
+
+		for _, slave := range g.Slaves {
+			if err := validateNodeAddr(slave); err != nil {
+				add("groups[%d].slaves: %v", i, err)
+			}
+		}
+
+		for _, err := range g.validate() {
+			add("groups[%d].%v", i, err)
+		}
+	}
+
+	switch c.Broker {
+	case "":
+	case "raft":
+		errs = append(errs, c.Raft.validate()...)
+	case "zk":
+		errs = append(errs, c.Zk.validate()...)
+	default:
+		add("broker: unknown broker %q, must be raft or zk", c.Broker)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (c *RaftConfig) validate() []error {
+	var errs []error
+
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(c.Addr) == 0 {
+		add("raft.addr: must be set for raft broker")
+	} else if err := validateAddr(c.Addr); err != nil {
+		add("raft.addr: %v", err)
+	}
+
+	if len(c.DataDir) == 0 {
+		add("raft.data_dir: must be set for raft broker")
+	}
+
+	found := false
+	for _, peer := range c.Cluster {
+		if err := validateAddr(peer); err != nil {
+			add("raft.cluster: %v", err)
+		}
+
+		if peer == c.Addr {
+			found = true
+		}
+	}
+
+	if len(c.Cluster) > 0 && len(c.Addr) > 0 && !found {
+		add("raft.cluster: must contain the local raft.addr %s", c.Addr)
+	}
+
+	switch c.ClusterState {
+	case "", ClusterStateNew, ClusterStateExisting:
+	default:
+		add("raft.cluster_state: must be %s or %s, not %q", ClusterStateNew, ClusterStateExisting, c.ClusterState)
+	}
+
+	return errs
+}
+
+func (c *ZkConfig) validate() []error {
+	var errs []error
+
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(c.Addr) == 0 {
+		add("zk.addr: must be set for zk broker")
+	}
+
+	for _, addr := range c.Addr {
+		if addr == "memory" {
+			// only for test
+			continue
+		}
+
+		if err := validateAddr(addr); err != nil {
+			add("zk.addr: %v", err)
+		}
+	}
+
+	if !strings.HasPrefix(c.BaseDir, "/zk") {
+		add("zk.base_dir: %q must have prefix /zk", c.BaseDir)
+	} else if path.Clean(c.BaseDir) != c.BaseDir {
+		add("zk.base_dir: %q is not a clean path, use %q", c.BaseDir, path.Clean(c.BaseDir))
+	}
+
+	return errs
+}
+
+// validateAddr checks the address is host:port
+func validateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("invalid address %q: bad host", addr)
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid address %q: bad port %q", addr, port)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package failover
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type configTestSuite struct {
+}
+
+var _ = Suite(&configTestSuite{})
+
+func (s *configTestSuite) TestValidate(c *C) {
+	cfg, err := NewConfigWithFile("../etc/failover.toml")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Validate(), IsNil)
+
+	cfg.Addr = "127.0.0.1"
+	cfg.Masters = []string{"127.0.0.1:6379", "127.0.0.1:abc"}
+	cfg.Raft.Cluster = []string{"127.0.0.1:12001"}
+	cfg.Raft.ClusterState = "exist"
+
+	err = cfg.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(ConfigError), HasLen, 4)
+
+	cfg = new(Config)
+	cfg.Broker = "zk"
+	cfg.Zk.BaseDir = "/zk/redis//failover/"
+	err = cfg.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(ConfigError), HasLen, 2)
+
+	cfg.Broker = "etcd"
+	err = cfg.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(ConfigError), HasLen, 1)
+}
+
+func (s *configTestSuite) TestUnknownKeys(c *C) {
+	_, err := NewConfig(`
+addr = "127.0.0.1:11000"
+max_down_tme = 3
+
+[raft]
+clusterstate = "new"
+`)
+	c.Assert(err, NotNil)
+	errs, ok := err.(ConfigError)
+	c.Assert(ok, Equals, true)
+	c.Assert(errs, HasLen, 2)
+	c.Assert(errs[0], ErrorMatches, "max_down_tme: unknown key")
+	c.Assert(errs[1], ErrorMatches, "raft.clusterstate: unknown key")
+}
@@ -0,0 +1,457 @@
+package failover
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/siddontang/go/log"
+)
+
+const (
+	// a toml file or a directory of toml files with [[groups]]
+	SourceFile = "file"
+	// a DNS SRV record, every target:port is a master
+	SourceDNSSRV = "dns_srv"
+	// an HTTP endpoint returning the JSON array of groups
+	SourceHTTP = "http"
+)
+
+var ErrMassRemoval = errors.New("too many masters would be removed, force the discovery to remove them")
+
+// A DiscoverySource finds the groups to be monitored.
+type DiscoverySource interface {
+	Name() string
+	Groups() ([]GroupConfig, error)
+}
+
+func newDiscoverySource(c SourceConfig) (DiscoverySource, error) {
+	switch c.Type {
+	case SourceFile:
+		return &fileSource{path: c.Path}, nil
+	case SourceDNSSRV:
+		return &dnsSource{name: c.Name}, nil
+	case SourceHTTP:
+		return &httpSource{url: c.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery source %q, must be %s, %s, %s or %s", c.Type, SourceFile, SourceDNSSRV, SourceHTTP, SourceKubernetes)
+	}
+}
+
+// discoverySource creates the source, the kubernetes source uses the [kubernetes] config.
+func (a *App) discoverySource(c *Config, sc SourceConfig) (DiscoverySource, error) {
+	if sc.Type != SourceKubernetes {
+		return newDiscoverySource(sc)
+	}
+
+	s, err := a.kubeSource(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", SourceKubernetes, err)
+	} else if s == nil {
+		return nil, fmt.Errorf("%s: kubernetes.selector is empty", SourceKubernetes)
+	}
+	return s, nil
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Name() string {
+	return fmt.Sprintf("%s:%s", SourceFile, s.path)
+}
+
+func (s *fileSource) Groups() ([]GroupConfig, error) {
+	st, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{s.path}
+	if st.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(s.path, "*.toml")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var groups []GroupConfig
+	for _, name := range files {
+		var v struct {
+			Groups []GroupConfig `toml:"groups"`
+		}
+
+		if _, err := toml.DecodeFile(name, &v); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		groups = append(groups, v.Groups...)
+	}
+
+	return groups, nil
+}
+
+type dnsSource struct {
+	name string
+}
+
+func (s *dnsSource) Name() string {
+	return fmt.Sprintf("%s:%s", SourceDNSSRV, s.name)
+}
+
+func (s *dnsSource) Groups() ([]GroupConfig, error) {
+	_, addrs, err := net.LookupSRV("", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]GroupConfig, 0, len(addrs))
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		groups = append(groups, GroupConfig{Master: net.JoinHostPort(host, fmt.Sprint(addr.Port))})
+	}
+	return groups, nil
+}
+
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) Name() string {
+	return fmt.Sprintf("%s:%s", SourceHTTP, s.url)
+}
+
+// Groups gets the JSON array like [{"master": "127.0.0.1:6379", "max_down_time": 5}].
+func (s *httpSource) Groups() ([]GroupConfig, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, data)
+	}
+
+	var groups []GroupConfig
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+
+	// the hooks run shell commands, never take them from the remote
+	for i := range groups {
+		groups[i].Hooks = HooksConfig{}
+	}
+	return groups, nil
+}
+
+// DiscoveryResult is the diff of one reconcile.
+type DiscoveryResult struct {
+	Time    time.Time `json:"time"`
+	Masters []string  `json:"masters"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+	// the removal is refused by the safeguard
+	Blocked bool `json:"blocked,omitempty"`
+	// the masters not removed because their groups are failing over
+	Skipped []string `json:"skipped,omitempty"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+type discovery struct {
+	// only one reconcile at the same time
+	run sync.Mutex
+
+	m    sync.Mutex
+	last *DiscoveryResult
+
+	// only used in discovery loop
+	lastRun time.Time
+}
+
+// runDiscovery reconciles the monitored masters with the discovery sources periodically.
+func (a *App) runDiscovery() {
+	defer a.wg.Done()
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			c := a.config()
+			if len(c.Discovery.Sources) == 0 || (a.cluster != nil && !a.cluster.IsLeader()) {
+				continue
+			}
+
+			now := time.Now()
+			if now.Sub(a.discovery.lastRun) < time.Duration(c.Discovery.Interval)*time.Second {
+				continue
+			}
+			a.discovery.lastRun = now
+
+			a.Discover(false, false)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// Discover finds the masters from all sources and reconciles the monitored masters with them.
+// If any source failed, no master is removed. If more than max_removal_percent masters
+// would be removed, only the new masters are added unless forced.
+func (a *App) Discover(force bool, dryRun bool) (*DiscoveryResult, error) {
+	if a.cluster != nil && !a.cluster.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	a.discovery.run.Lock()
+	defer a.discovery.run.Unlock()
+
+	c := a.config()
+	r := &DiscoveryResult{Time: time.Now(), DryRun: dryRun}
+
+	if len(c.Discovery.Sources) == 0 {
+		return nil, fmt.Errorf("no discovery source")
+	}
+
+	groups := make([]GroupConfig, 0, len(c.Groups))
+	groups = append(groups, c.Groups...)
+	for _, master := range c.Masters {
+		groups = append(groups, GroupConfig{Master: master})
+	}
+
+	for _, sc := range c.Discovery.Sources {
+		s, err := a.discoverySource(c, sc)
+		if err == nil {
+			var gs []GroupConfig
+			if gs, err = s.Groups(); err == nil {
+				err = validateGroups(gs)
+			}
+
+			if err == nil {
+				groups = append(groups, gs...)
+				continue
+			}
+			err = fmt.Errorf("%s: %v", s.Name(), err)
+		}
+
+		log.Errorf("discovery err %v", err)
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	current := a.masters.GetMasters()
+	desired := a.reconcileMasters(groups)
+
+	r.Masters = make([]string, 0, len(desired))
+	for addr := range desired {
+		r.Masters = append(r.Masters, addr)
+		if !a.masters.IsMaster(addr) {
+			r.Added = append(r.Added, addr)
+		}
+	}
+
+	for _, addr := range current {
+		if _, ok := desired[addr]; ok {
+			continue
+		}
+
+		// the redis cluster shard masters are managed by redis_cluster
+		if a.masters.GetOptions(addr).Backend == BackendCluster {
+			r.Masters = append(r.Masters, addr)
+		} else {
+			r.Removed = append(r.Removed, addr)
+		}
+	}
+
+	sort.Strings(r.Masters)
+	sort.Strings(r.Added)
+	sort.Strings(r.Removed)
+
+	var err error
+	if len(r.Removed) > 0 {
+		if len(r.Errors) > 0 {
+			// the masters of the failed source would be removed
+			r.Blocked = true
+			err = fmt.Errorf("discovery sources failed, refuse to remove %v", r.Removed)
+		} else if !force && len(r.Removed)*100 > c.Discovery.MaxRemovalPercent*len(current) {
+			r.Blocked = true
+			err = ErrMassRemoval
+		}
+	}
+
+	if !dryRun {
+		a.applyDiscovery(r, desired)
+
+		if err := a.syncKubeEndpoints(); err != nil {
+			log.Errorf("sync kubernetes endpoints err %v", err)
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+
+	a.discovery.m.Lock()
+	if !dryRun {
+		a.discovery.last = r
+	}
+	a.discovery.m.Unlock()
+
+	return r, err
+}
+
+// reconcileMasters maps the discovered groups to the monitored masters,
+// the discovered master may be a slave now after failover, then we keep the monitored master.
+func (a *App) reconcileMasters(groups []GroupConfig) map[string]GroupConfig {
+	// slave -> the monitored master
+	slaves := make(map[string]string)
+	for master, ss := range a.masters.GetAllSlaves() {
+		for _, slave := range ss {
+			slaves[slave] = master
+		}
+	}
+
+	// old master -> new master in failover
+	promoted := make(map[string]string)
+	for _, r := range a.history.Records() {
+		if len(r.NewMaster) > 0 && len(r.Error) == 0 && !r.DryRun {
+			promoted[r.Master] = r.NewMaster
+		}
+	}
+
+	desired := make(map[string]GroupConfig, len(groups))
+	for _, g := range groups {
+		addr := g.Master
+		if !a.masters.IsMaster(addr) {
+			if master, ok := slaves[addr]; ok {
+				addr = master
+			} else {
+				// follow the failovers at most historySize times
+				for i := 0; i < historySize; i++ {
+					next, ok := promoted[addr]
+					if !ok {
+						break
+					}
+					addr = next
+				}
+
+				if !a.masters.IsMaster(addr) {
+					addr = g.Master
+				}
+			}
+		}
+
+		// the first definition wins
+		if _, ok := desired[addr]; !ok {
+			desired[addr] = g
+		}
+	}
+
+	return desired
+}
+
+func (a *App) applyDiscovery(r *DiscoveryResult, desired map[string]GroupConfig) {
+	if len(r.Added) > 0 || len(r.Removed) > 0 {
+		log.Infof("discovery added %v, removed %v, blocked %v", r.Added, r.Removed, r.Blocked)
+	}
+
+	if len(r.Added) > 0 {
+		if err := a.addMasters(r.Added); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+
+	if r.Blocked {
+		log.Errorf("discovery refuses to remove %v", r.Removed)
+		a.events.Publish(&Event{
+			Type: EventDiscoveryBlocked,
+			Data: map[string]interface{}{"removed": r.Removed, "errors": r.Errors},
+		})
+	} else if len(r.Removed) > 0 {
+		r.Removed, r.Skipped = a.removableMasters(r.Removed)
+		if len(r.Skipped) > 0 {
+			log.Infof("discovery skips removing %v, they are failing over", r.Skipped)
+		}
+
+		// only delete the removed masters, the masters may be changed by failover after reconciling
+		if err := a.delMasters(r.Removed); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+
+	for addr, g := range desired {
+		if o := g.GroupOptions; o != (GroupOptions{}) && !a.masters.GetOptions(addr).equal(o) {
+			if err := a.setGroupOptions([]string{addr}, &o); err != nil {
+				r.Errors = append(r.Errors, err.Error())
+			}
+		}
+
+		// the slaves are used only if we haven't found any
+		if len(g.Slaves) > 0 && len(a.masters.GetSlaves(addr)) == 0 {
+			a.setSlaves(addr, g.Slaves)
+		}
+	}
+
+	if len(r.Added) > 0 || (len(r.Removed) > 0 && !r.Blocked) {
+		removed := r.Removed
+		if r.Blocked {
+			removed = nil
+		}
+		a.events.Publish(&Event{
+			Type: EventDiscoveryReconciled,
+			Data: map[string]interface{}{"added": r.Added, "removed": removed},
+		})
+	}
+}
+
+// removableMasters splits the masters into the ones can be removed and the ones whose
+// groups are doing or waiting for failover, the failover will change the masters itself.
+func (a *App) removableMasters(addrs []string) ([]string, []string) {
+	a.gMutex.Lock()
+	defer a.gMutex.Unlock()
+
+	var removable, skipped []string
+	for _, addr := range addrs {
+		if g, ok := a.groups[addr]; ok && (g.busy.Get() == 1 || g.pending.Get() == 1) {
+			skipped = append(skipped, addr)
+		} else {
+			removable = append(removable, addr)
+		}
+	}
+	return removable, skipped
+}
+
+// LastDiscovery returns the result of the last reconcile.
+func (a *App) LastDiscovery() *DiscoveryResult {
+	a.discovery.m.Lock()
+	defer a.discovery.m.Unlock()
+
+	return a.discovery.last
+}
+
+func validateGroups(groups []GroupConfig) error {
+	for _, g := range groups {
+		if err := validateNodeAddr(g.Master); err != nil {
+			return err
+		}
+
+		if errs := g.validate(); len(errs) > 0 {
+			return fmt.Errorf("group %s: %v", g.Master, ConfigError(errs))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package failover
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type discoveryTestSuite struct {
+}
+
+var _ = Suite(&discoveryTestSuite{})
+
+func newDiscoveryApp(c *Config) *App {
+	c.adjust()
+
+	a := new(App)
+	a.c = c
+	a.events = newEventHub(eventBufferSize)
+	a.history = newFailoverHistory()
+	a.masters = newMasterFSM()
+	return a
+}
+
+func (s *discoveryTestSuite) TestSources(c *C) {
+	dir, err := ioutil.TempDir("", "discovery")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "a.toml"), []byte(`
+[[groups]]
+master = "127.0.0.1:6379"
+slaves = ["127.0.0.1:6380"]
+max_down_time = 5
+`), 0644)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "b.toml"), []byte(`
+[[groups]]
+master = "127.0.0.1:6381"
+`), 0644)
+	c.Assert(err, IsNil)
+
+	groups, err := (&fileSource{path: dir}).Groups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 2)
+	c.Assert(groups[0].Master, Equals, "127.0.0.1:6379")
+	c.Assert(groups[0].Slaves, DeepEquals, []string{"127.0.0.1:6380"})
+	c.Assert(groups[0].MaxDownTime, Equals, 5)
+	c.Assert(groups[1].Master, Equals, "127.0.0.1:6381")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"master": "127.0.0.1:6382", "elect_strategy": "offset"}]`))
+	}))
+	defer ts.Close()
+
+	groups, err = (&httpSource{url: ts.URL, client: http.DefaultClient}).Groups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 1)
+	c.Assert(groups[0].Master, Equals, "127.0.0.1:6382")
+	c.Assert(groups[0].ElectStrategy, Equals, ElectByOffset)
+
+	_, err = newDiscoverySource(SourceConfig{Type: "consul"})
+	c.Assert(err, NotNil)
+}
+
+func (s *discoveryTestSuite) TestDiscover(c *C) {
+	masters := []string{"127.0.0.1:6379"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(masters) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("["))
+		for i, m := range masters {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			w.Write([]byte(`{"master": "` + m + `"}`))
+		}
+		w.Write([]byte("]"))
+	}))
+	defer ts.Close()
+
+	a := newDiscoveryApp(&Config{
+		Masters:   []string{"127.0.0.1:7000"},
+		Discovery: DiscoveryConfig{MaxRemovalPercent: 50, Sources: []SourceConfig{{Type: SourceHTTP, URL: ts.URL}}},
+	})
+	a.masters.AddMasters([]string{"127.0.0.1:6390", "127.0.0.1:7000"})
+
+	// a dry run shows the diff only
+	r, err := a.Discover(false, true)
+	c.Assert(err, IsNil)
+	c.Assert(r.Added, DeepEquals, []string{"127.0.0.1:6379"})
+	c.Assert(r.Removed, DeepEquals, []string{"127.0.0.1:6390"})
+	c.Assert(a.masters.IsMaster("127.0.0.1:6390"), Equals, true)
+	c.Assert(a.LastDiscovery(), IsNil)
+
+	r, err = a.Discover(false, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.Blocked, Equals, false)
+	c.Assert(a.masters.GetMasters(), HasLen, 2)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6379"), Equals, true)
+	c.Assert(a.LastDiscovery(), Equals, r)
+
+	// 127.0.0.1:6379 failed over to 127.0.0.1:6380, the source still reports the old one
+	a.masters.DelMasters([]string{"127.0.0.1:6379"})
+	a.masters.AddMasters([]string{"127.0.0.1:6380"})
+	a.history.End(a.history.Begin("127.0.0.1:6379", FailoverReasonDown), "127.0.0.1:6380", nil)
+
+	r, err = a.Discover(false, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.Added, HasLen, 0)
+	c.Assert(r.Removed, HasLen, 0)
+
+	// removing one of two masters is refused
+	a.c.Discovery.MaxRemovalPercent = 40
+	masters = []string{"127.0.0.1:6391"}
+	r, err = a.Discover(false, false)
+	c.Assert(err, Equals, ErrMassRemoval)
+	c.Assert(r.Blocked, Equals, true)
+	c.Assert(r.Added, DeepEquals, []string{"127.0.0.1:6391"})
+	c.Assert(r.Removed, DeepEquals, []string{"127.0.0.1:6380"})
+	c.Assert(a.masters.IsMaster("127.0.0.1:6380"), Equals, true)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6391"), Equals, true)
+
+	// the failed source removes nothing even if forced
+	masters = nil
+	r, err = a.Discover(true, false)
+	c.Assert(err, NotNil)
+	c.Assert(r.Blocked, Equals, true)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6380"), Equals, true)
+
+	masters = []string{"127.0.0.1:6391"}
+	r, err = a.Discover(true, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.Removed, DeepEquals, []string{"127.0.0.1:6380"})
+	c.Assert(a.masters.IsMaster("127.0.0.1:6380"), Equals, false)
+	c.Assert(a.masters.IsMaster("127.0.0.1:7000"), Equals, true)
+}
+
+func (s *discoveryTestSuite) TestDiscoverOptions(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"master": "127.0.0.1:6379", "auto_failover": false, "max_down_time": 5, "hooks": {"before_failover": "touch /tmp/x"}}]`))
+	}))
+	defer ts.Close()
+
+	groups, err := (&httpSource{url: ts.URL, client: http.DefaultClient}).Groups()
+	c.Assert(err, IsNil)
+	c.Assert(groups[0].Hooks, Equals, HooksConfig{})
+
+	a := newDiscoveryApp(&Config{
+		Discovery: DiscoveryConfig{Sources: []SourceConfig{{Type: SourceHTTP, URL: ts.URL}}},
+	})
+	f := &fakeCluster{fsm: a.masters, leader: true}
+	a.cluster = f
+
+	_, err = a.Discover(false, false)
+	c.Assert(err, IsNil)
+	c.Assert(f.optionWrites, Equals, 1)
+
+	o := a.masters.GetOptions("127.0.0.1:6379")
+	c.Assert(o.IsAutoFailover(), Equals, false)
+	c.Assert(o.MaxDownTime, Equals, 5)
+
+	// the same options decoded again are not written again
+	_, err = a.Discover(false, false)
+	c.Assert(err, IsNil)
+	c.Assert(f.optionWrites, Equals, 1)
+}
+
+func (s *discoveryTestSuite) TestDiscoverFailover(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"master": "127.0.0.1:6379"}, {"master": "127.0.0.1:6381"}]`))
+	}))
+	defer ts.Close()
+
+	a := newCheckApp(&Config{
+		Addr:      "127.0.0.1:11000",
+		Discovery: DiscoveryConfig{MaxRemovalPercent: 100, Sources: []SourceConfig{{Type: SourceHTTP, URL: ts.URL}}},
+	})
+	for _, addr := range []string{"127.0.0.1:6379", "127.0.0.1:6381", "127.0.0.1:6390", "127.0.0.1:6391"} {
+		addTestGroup(a, addr, GroupOptions{})
+	}
+
+	r, err := a.Discover(false, true)
+	c.Assert(err, IsNil)
+	c.Assert(r.Removed, DeepEquals, []string{"127.0.0.1:6390", "127.0.0.1:6391"})
+
+	// 127.0.0.1:6379 fails over to 127.0.0.1:6380 before applying
+	a.masters.DelMasters([]string{"127.0.0.1:6379"})
+	a.masters.AddMasters([]string{"127.0.0.1:6380"})
+
+	// and 127.0.0.1:6391 is waiting for the failover
+	a.groups["127.0.0.1:6391"].pending.Set(1)
+
+	a.applyDiscovery(r, map[string]GroupConfig{})
+	c.Assert(r.Removed, DeepEquals, []string{"127.0.0.1:6390"})
+	c.Assert(r.Skipped, DeepEquals, []string{"127.0.0.1:6391"})
+
+	// the new master is kept, the old one is not back
+	c.Assert(a.masters.IsMaster("127.0.0.1:6380"), Equals, true)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6379"), Equals, false)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6390"), Equals, false)
+	c.Assert(a.masters.IsMaster("127.0.0.1:6391"), Equals, true)
+}
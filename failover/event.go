@@ -0,0 +1,145 @@
+package failover
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EventMasterAdded     = "master_added"
+	EventMasterRemoved   = "master_removed"
+	EventSlaveAdded      = "slave_added"
+	EventSlaveRemoved    = "slave_removed"
+	EventSlaveUnhealthy  = "slave_unhealthy"
+	EventSlaveHealthy    = "slave_healthy"
+	EventCheckFailed     = "check_failed"
+	EventLeaderChanged   = "leader_changed"
+	EventFailoverPending = "failover_pending"
+	EventFailoverBegin   = "failover_begin"
+	EventFailoverElect   = "failover_elect"
+	EventFailoverDone    = "failover_done"
+	EventFailoverFailed  = "failover_failed"
+	EventWouldFailover   = "would_failover"
+	EventFailoverSkipped = "failover_skipped"
+	EventCircuitOpen     = "circuit_open"
+	EventCircuitClosed   = "circuit_closed"
+
+	EventTopologyVerified = "topology_verified"
+
+	EventMaintenanceEnter = "maintenance_enter"
+	EventMaintenanceLeave = "maintenance_leave"
+
+	EventDiscoveryReconciled = "discovery_reconciled"
+	EventDiscoveryBlocked    = "discovery_blocked"
+
+	EventEndpointsUpdated = "endpoints_updated"
+)
+
+// how many recent events we keep for resuming
+const eventBufferSize = 1024
+
+// An event describes a topology or state change seen by this node.
+// ID is increased monotonically and can be used as the resume cursor.
+type Event struct {
+	ID     uint64                 `json:"id"`
+	Type   string                 `json:"type"`
+	Time   time.Time              `json:"time"`
+	Master string                 `json:"master,omitempty"`
+	Node   string                 `json:"node,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+type eventHub struct {
+	m sync.Mutex
+
+	lastID uint64
+
+	// ring buffer for recent events
+	buf  []*Event
+	head int
+
+	subs map[chan *Event]struct{}
+}
+
+func newEventHub(size int) *eventHub {
+	h := new(eventHub)
+	h.buf = make([]*Event, 0, size)
+	h.subs = make(map[chan *Event]struct{})
+	return h
+}
+
+// Publish assigns an ID to the event and sends it to all subscribers.
+// A subscriber which can not keep up is dropped, it can resume later with the cursor.
+func (h *eventHub) Publish(e *Event) {
+	if h == nil {
+		return
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.lastID++
+	e.ID = h.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if len(h.buf) < cap(h.buf) {
+		h.buf = append(h.buf, e)
+	} else {
+		h.buf[h.head] = e
+		h.head = (h.head + 1) % len(h.buf)
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after the cursor and a channel for the later events.
+// If the cursor is beyond the last event, e.g, this node restarted, all buffered events are returned.
+func (h *eventHub) Subscribe(cursor uint64) ([]*Event, chan *Event) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if cursor > h.lastID {
+		cursor = 0
+	}
+
+	backlog := make([]*Event, 0, len(h.buf))
+	for i := 0; i < len(h.buf); i++ {
+		e := h.buf[(h.head+i)%len(h.buf)]
+		if e.ID > cursor {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan *Event, 64)
+	h.subs[ch] = struct{}{}
+	return backlog, ch
+}
+
+func (h *eventHub) Unsubscribe(ch chan *Event) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) Close() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for ch := range h.subs {
+		close(ch)
+	}
+	h.subs = make(map[chan *Event]struct{})
+}
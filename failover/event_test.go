@@ -0,0 +1,66 @@
+package failover
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type eventTestSuite struct {
+}
+
+var _ = Suite(&eventTestSuite{})
+
+func (s *eventTestSuite) TestResume(c *C) {
+	h := newEventHub(4)
+
+	for i := 0; i < 6; i++ {
+		h.Publish(&Event{Type: EventMasterAdded})
+	}
+
+	// only the latest 4 events are kept
+	backlog, ch := h.Subscribe(0)
+	c.Assert(backlog, HasLen, 4)
+	c.Assert(backlog[0].ID, Equals, uint64(3))
+	c.Assert(backlog[3].ID, Equals, uint64(6))
+	h.Unsubscribe(ch)
+
+	backlog, ch = h.Subscribe(5)
+	c.Assert(backlog, HasLen, 1)
+	c.Assert(backlog[0].ID, Equals, uint64(6))
+
+	h.Publish(&Event{Type: EventMasterRemoved})
+	e := <-ch
+	c.Assert(e.ID, Equals, uint64(7))
+	c.Assert(e.Type, Equals, EventMasterRemoved)
+	h.Unsubscribe(ch)
+
+	// cursor from a restarted node, replay all
+	backlog, ch = h.Subscribe(100)
+	c.Assert(backlog, HasLen, 4)
+	h.Unsubscribe(ch)
+}
+
+func (s *eventTestSuite) TestMasterChange(c *C) {
+	h := newEventHub(16)
+
+	fsm := newMasterFSM()
+	fsm.onChange = func(added []string, removed []string) {
+		for _, addr := range added {
+			h.Publish(&Event{Type: EventMasterAdded, Master: addr})
+		}
+		for _, addr := range removed {
+			h.Publish(&Event{Type: EventMasterRemoved, Master: addr})
+		}
+	}
+
+	fsm.AddMasters([]string{"127.0.0.1:6379"})
+	fsm.AddMasters([]string{"127.0.0.1:6379"})
+	fsm.SetMasters([]string{"127.0.0.1:6380"})
+	fsm.DelMasters([]string{"127.0.0.1:6381"})
+
+	backlog, _ := h.Subscribe(0)
+	c.Assert(backlog, HasLen, 3)
+	c.Assert(backlog[0].Type, Equals, EventMasterAdded)
+	c.Assert(backlog[1].Master, Equals, "127.0.0.1:6380")
+	c.Assert(backlog[2].Type, Equals, EventMasterRemoved)
+	c.Assert(backlog[2].Master, Equals, "127.0.0.1:6379")
+}
@@ -0,0 +1,484 @@
+package failover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+// fakeStatus is the status reply, like +OK.
+type fakeStatus string
+
+// fakeRedisSet is a set of fake redis servers replicating from each other,
+// the master finds its slaves in the set, like the real ROLE.
+type fakeRedisSet struct {
+	m     sync.Mutex
+	nodes []*fakeRedis
+}
+
+// fakeRedis is a redis server keeping the replication state in memory,
+// so we can test the failover without the real redis.
+type fakeRedis struct {
+	set  *fakeRedisSet
+	l    net.Listener
+	Addr string
+
+	// the fields below are protected by set.m
+
+	// host:port of the master, empty if it is a master
+	master   string
+	offset   int64
+	priority int
+	version  string
+	// if true, the slave doesn't sync the offset from its master
+	noSync bool
+	// if true, the slave reports the master link is down
+	linkDown bool
+	// if not empty, reply the error to all commands, like BUSY
+	replyErr string
+	// the commands received, like "SLAVEOF no one"
+	cmds   []string
+	config map[string]string
+	data   map[string]string
+	conns  []net.Conn
+	down   bool
+
+	// handles the command before the fake if returns true
+	hook func(args []string) (interface{}, bool)
+}
+
+// newCheckApp returns the App checking the groups without cluster.
+func newCheckApp(c *Config) *App {
+	a := newDiscoveryApp(c)
+	a.limiter = newFailoverLimiter()
+	a.groups = make(map[string]*Group)
+	a.masters.onChange = a.onMastersChange
+	a.masters.onMaintenance = a.onMaintenanceChange
+	return a
+}
+
+// addTestGroup monitors the master and creates its group like the check loop.
+func addTestGroup(a *App, master string, opts GroupOptions) *Group {
+	c := a.config()
+
+	a.masters.AddMasters([]string{master})
+	a.masters.SetOptions([]string{master}, &opts)
+
+	g := newGroup(master)
+	g.events = a.events
+	g.SeedSlaves(a.knownSlaves(c, master))
+	g.SetOptions(opts, opts.effective(c, a.groupHooks(c, master)))
+
+	a.gMutex.Lock()
+	a.groups[master] = g
+	a.gMutex.Unlock()
+	return g
+}
+
+// newFakeGroup returns the group of the fake master, checked once to find the slaves.
+func newFakeGroup(c *C, master *fakeRedis, opts GroupOptions) *Group {
+	g := newGroup(master.Addr)
+	g.SetOptions(opts, opts.effective(&Config{}, HooksConfig{}))
+	c.Assert(g.Check(), IsNil)
+	return g
+}
+
+// eventTypes returns the types of the published events.
+func eventTypes(h *eventHub) []string {
+	backlog, ch := h.Subscribe(0)
+	h.Unsubscribe(ch)
+
+	types := make([]string, 0, len(backlog))
+	for _, e := range backlog {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func newFakeRedisSet() *fakeRedisSet {
+	return new(fakeRedisSet)
+}
+
+// add starts a fake redis, which replicates from the master if not nil.
+func (s *fakeRedisSet) add(master *fakeRedis) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	n := &fakeRedis{
+		set:      s,
+		l:        l,
+		Addr:     l.Addr().String(),
+		priority: 100,
+		version:  "6.2.0",
+		config:   map[string]string{"min-replicas-to-write": "0"},
+		data:     make(map[string]string),
+	}
+
+	if master != nil {
+		n.master = master.Addr
+	}
+
+	s.m.Lock()
+	s.nodes = append(s.nodes, n)
+	s.m.Unlock()
+
+	go n.serve()
+	return n
+}
+
+func (s *fakeRedisSet) close() {
+	s.m.Lock()
+	nodes := s.nodes
+	s.m.Unlock()
+
+	for _, n := range nodes {
+		n.close()
+	}
+}
+
+// find returns the node, the caller must hold set.m.
+func (s *fakeRedisSet) find(addr string) *fakeRedis {
+	for _, n := range s.nodes {
+		if n.Addr == addr {
+			return n
+		}
+	}
+	return nil
+}
+
+// update changes the node state with the set locked.
+func (n *fakeRedis) update(f func(n *fakeRedis)) {
+	n.set.m.Lock()
+	defer n.set.m.Unlock()
+
+	f(n)
+}
+
+// commands returns the commands received with the prefix.
+func (n *fakeRedis) commands(prefix string) []string {
+	n.set.m.Lock()
+	defer n.set.m.Unlock()
+
+	var cmds []string
+	for _, cmd := range n.cmds {
+		if strings.HasPrefix(cmd, prefix) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func (n *fakeRedis) getConfig(name string) string {
+	n.set.m.Lock()
+	defer n.set.m.Unlock()
+
+	return n.config[name]
+}
+
+func (n *fakeRedis) getMaster() string {
+	n.set.m.Lock()
+	defer n.set.m.Unlock()
+
+	return n.master
+}
+
+// close stops the server, the node is down now.
+func (n *fakeRedis) close() {
+	n.l.Close()
+
+	n.set.m.Lock()
+	conns := n.conns
+	n.conns = nil
+	n.down = true
+	n.set.m.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (n *fakeRedis) serve() {
+	for {
+		c, err := n.l.Accept()
+		if err != nil {
+			return
+		}
+
+		n.set.m.Lock()
+		n.conns = append(n.conns, c)
+		n.set.m.Unlock()
+
+		go n.serveConn(c)
+	}
+}
+
+func (n *fakeRedis) serveConn(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+
+		n.set.m.Lock()
+		n.cmds = append(n.cmds, strings.Join(args, " "))
+		hook := n.hook
+		n.set.m.Unlock()
+
+		var v interface{}
+		ok := false
+		if hook != nil {
+			// the hook may block, so call it without the lock
+			v, ok = hook(args)
+		}
+
+		if !ok {
+			n.set.m.Lock()
+			v = n.do(strings.ToUpper(args[0]), args[1:])
+			n.set.m.Unlock()
+		}
+
+		writeFakeReply(w, v)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// do replies the command, the caller must hold set.m.
+func (n *fakeRedis) do(cmd string, args []string) interface{} {
+	if len(n.replyErr) > 0 {
+		return redis.Error(n.replyErr)
+	}
+
+	switch cmd {
+	case "PING":
+		return fakeStatus("PONG")
+	case "ROLE":
+		if len(n.master) > 0 {
+			host, port, _ := net.SplitHostPort(n.master)
+			return []interface{}{"slave", host, port, "connected", n.offset}
+		}
+
+		slaves := []interface{}{}
+		for _, s := range n.slaves() {
+			s.sync()
+			host, port, _ := net.SplitHostPort(s.Addr)
+			slaves = append(slaves, []interface{}{host, port, strconv.FormatInt(s.offset, 10)})
+		}
+		return []interface{}{"master", n.offset, slaves}
+	case "INFO":
+		section := ""
+		if len(args) > 0 {
+			section = strings.ToLower(args[0])
+		}
+		return n.info(section)
+	case "SLAVEOF", "REPLICAOF":
+		if len(args) != 2 {
+			return redis.Error("ERR wrong number of arguments")
+		}
+
+		if strings.ToLower(args[0]) == "no" && strings.ToLower(args[1]) == "one" {
+			n.master = ""
+		} else {
+			n.master = net.JoinHostPort(args[0], args[1])
+			n.sync()
+		}
+		return fakeStatus("OK")
+	case "CONFIG":
+		if len(args) == 0 {
+			return redis.Error("ERR wrong number of arguments")
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			if v, ok := n.config[args[1]]; ok {
+				return []interface{}{args[1], v}
+			}
+			return []interface{}{}
+		case "SET":
+			if _, ok := n.config[args[1]]; !ok {
+				return redis.Error("ERR Unknown option or number of arguments for CONFIG SET - '" + args[1] + "'")
+			}
+			n.config[args[1]] = args[2]
+			return fakeStatus("OK")
+		case "REWRITE":
+			return fakeStatus("OK")
+		}
+	case "CLIENT":
+		if len(args) > 0 && (strings.ToUpper(args[0]) == "PAUSE" || strings.ToUpper(args[0]) == "UNPAUSE") {
+			return fakeStatus("OK")
+		}
+	case "SET":
+		if len(args) < 2 {
+			return redis.Error("ERR wrong number of arguments")
+		}
+		n.data[args[0]] = args[1]
+		n.offset += int64(len(args[0]) + len(args[1]))
+		return fakeStatus("OK")
+	case "GET":
+		if v, ok := n.data[args[0]]; ok {
+			return v
+		}
+		return nil
+	}
+
+	return redis.Error(fmt.Sprintf("ERR unknown command '%s'", cmd))
+}
+
+// slaves returns the nodes replicating from it, the caller must hold set.m.
+func (n *fakeRedis) slaves() []*fakeRedis {
+	var slaves []*fakeRedis
+	for _, s := range n.set.nodes {
+		if s.master == n.Addr && s.isUp() {
+			slaves = append(slaves, s)
+		}
+	}
+	return slaves
+}
+
+// sync lets the slave catch up with its master at once, the caller must hold set.m.
+func (n *fakeRedis) sync() {
+	if n.noSync {
+		return
+	}
+
+	if m := n.set.find(n.master); m != nil && m.isUp() && m.offset > n.offset {
+		n.offset = m.offset
+	}
+}
+
+// isUp returns true if the server is not closed, the caller must hold set.m.
+func (n *fakeRedis) isUp() bool {
+	return !n.down
+}
+
+func (n *fakeRedis) info(section string) string {
+	m := make(map[string]string)
+	switch section {
+	case "server":
+		m["redis_version"] = n.version
+	case "persistence":
+		m["rdb_last_bgsave_status"] = "ok"
+		m["aof_last_write_status"] = "ok"
+	case "replication":
+		if len(n.master) == 0 {
+			m["role"] = MasterType
+			m["master_repl_offset"] = strconv.FormatInt(n.offset, 10)
+			m["connected_slaves"] = strconv.Itoa(len(n.slaves()))
+			for i, s := range n.slaves() {
+				s.sync()
+				host, port, _ := net.SplitHostPort(s.Addr)
+				m[fmt.Sprintf("slave%d", i)] = fmt.Sprintf("ip=%s,port=%s,state=online,offset=%d,lag=0", host, port, s.offset)
+			}
+			break
+		}
+
+		n.sync()
+
+		host, port, _ := net.SplitHostPort(n.master)
+		m["role"] = SlaveType
+		m["master_host"] = host
+		m["master_port"] = port
+		m["slave_repl_offset"] = strconv.FormatInt(n.offset, 10)
+		m["slave_priority"] = strconv.Itoa(n.priority)
+
+		if master := n.set.find(n.master); master != nil && master.isUp() && !n.linkDown {
+			m["master_link_status"] = "up"
+			m["master_last_io_seconds_ago"] = "0"
+		} else {
+			m["master_link_status"] = "down"
+		}
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := fmt.Sprintf("# %s\r\n", section)
+	for _, k := range keys {
+		s += fmt.Sprintf("%s:%s\r\n", k, m[k])
+	}
+	return s
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "*") {
+		// the inline command
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty command")
+		}
+		return args, nil
+	}
+
+	num, err := strconv.Atoi(line[1:])
+	if err != nil || num <= 0 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, num)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeFakeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case fakeStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case redis.Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeFakeReply(w, e)
+		}
+	default:
+		panic(fmt.Sprintf("invalid fake reply %T", v))
+	}
+}
@@ -0,0 +1,262 @@
+package failover
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/go/log"
+)
+
+const (
+	FenceNone = "none"
+	// CLIENT PAUSE WRITE, supported from redis 6.2
+	FencePause = "pause"
+	// set a huge min-replicas-to-write, the master rejects all writes
+	FenceMinReplicas = "min_replicas"
+)
+
+const fenceMinReplicas = 1 << 30
+
+// A fence stops the writes to the old master before promoting a slave,
+// so clients can't write to the master which is about to be demoted.
+type fence struct {
+	addr string
+	mode string
+	do   func(cmd string, args ...interface{}) (interface{}, error)
+
+	// only used for the down master
+	conn redis.Conn
+
+	// the min-replicas-to-write config name and the old value
+	name  string
+	value string
+}
+
+func (f *fence) lock(mode string, timeout time.Duration) error {
+	if mode == FencePause {
+		_, err := f.do("CLIENT", "PAUSE", int64(timeout/time.Millisecond), "WRITE")
+		if err == nil {
+			f.mode = FencePause
+			return nil
+		}
+
+		log.Errorf("client pause write %s err %v, use %s instead", f.addr, err, FenceMinReplicas)
+	}
+
+	// min-slaves-to-write before redis 5.0
+	for _, name := range []string{"min-replicas-to-write", "min-slaves-to-write"} {
+		v, err := redis.Strings(f.do("CONFIG", "GET", name))
+		if err != nil {
+			return err
+		}
+
+		if len(v) == 2 {
+			f.name = name
+			f.value = v[1]
+			break
+		}
+	}
+
+	if len(f.name) == 0 {
+		return fmt.Errorf("%s doesn't support min-replicas-to-write", f.addr)
+	}
+
+	if _, err := f.do("CONFIG", "SET", f.name, fenceMinReplicas); err != nil {
+		return err
+	}
+
+	f.mode = FenceMinReplicas
+	return nil
+}
+
+// release undoes the fence.
+func (f *fence) release() {
+	var err error
+	switch f.mode {
+	case FencePause:
+		_, err = f.do("CLIENT", "UNPAUSE")
+	case FenceMinReplicas:
+		_, err = f.do("CONFIG", "SET", f.name, f.value)
+	}
+
+	if err != nil {
+		log.Errorf("release %s fence for %s err %v", f.mode, f.addr, err)
+	} else {
+		log.Infof("release %s fence for %s ok", f.mode, f.addr)
+	}
+}
+
+func (f *fence) String() string {
+	if f.mode == FenceMinReplicas {
+		return fmt.Sprintf("%s %d, was %s", f.name, fenceMinReplicas, f.value)
+	}
+	return f.mode
+}
+
+func (f *fence) close() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+// fenceAlive stops the writes to the alive master and waits the candidate to catch up with it.
+// It uses its own connection to the master, so the group lock is not held while waiting,
+// the caller must close the fence.
+func (g *Group) fenceAlive(addr string, mode string, timeout time.Duration) (*fence, error) {
+	g.m.Lock()
+	node := g.Slaves[addr]
+	master := g.Master.Addr
+	g.m.Unlock()
+
+	if node == nil {
+		return nil, fmt.Errorf("%s is not the slave of master %s", addr, master)
+	}
+
+	conn, err := dialAddr(master, time.Second, time.Second, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("fence master %s err %v", master, err)
+	}
+
+	f := &fence{addr: master, do: conn.Do, conn: conn}
+	if err := f.lock(mode, timeout); err != nil {
+		f.close()
+		return nil, fmt.Errorf("fence master %s err %v", master, err)
+	}
+
+	log.Infof("fence master %s with %s", master, f.mode)
+
+	r, err := g.backend().Role(f.do)
+	if err != nil {
+		f.release()
+		f.close()
+		return nil, err
+	}
+
+	// the master offset won't grow now
+	target := r.Offset
+	if offset, ok := g.waitOffset(addr, target, timeout/2); !ok {
+		f.release()
+		f.close()
+		return nil, fmt.Errorf("slave %s can't catch up with master %s in %s, offset %d < %d",
+			addr, master, timeout/2, offset, target)
+	}
+
+	return f, nil
+}
+
+// tryFence stops the writes to the down master if it is still reachable by others,
+// e.g, only we are partitioned from it. Returns nil if we can't.
+func (g *Group) tryFence(mode string, timeout time.Duration) *fence {
+	g.m.Lock()
+	addr := g.Master.Addr
+	g.m.Unlock()
+
+	conn, err := dialAddr(addr, time.Second, time.Second, time.Second)
+	if err != nil {
+		return nil
+	}
+
+	f := &fence{addr: addr, do: conn.Do, conn: conn}
+	if err := f.lock(mode, timeout); err != nil {
+		log.Errorf("fence down master %s err %v", addr, err)
+		f.close()
+		return nil
+	}
+
+	log.Infof("fence down master %s with %s", addr, f.mode)
+	return f
+}
+
+// DemoteError means the slave is promoted, but the old master can't replicate from it,
+// both of them are masters now and the old one must be demoted manually.
+type DemoteError struct {
+	Master    string
+	NewMaster string
+	Err       error
+}
+
+func (e *DemoteError) Error() string {
+	return fmt.Sprintf("promoted %s, but slaveof old master %s to it err %v, %s is still a master",
+		e.NewMaster, e.Master, e.Err, e.Master)
+}
+
+// demoteFenced lets the fenced down master replicate from the new master, then releases the fence,
+// otherwise the old master with min_replicas fence rejects all writes forever after it rejoins.
+// If it can't be demoted, the fence is kept, it is still a master and must not accept writes.
+func (g *Group) demoteFenced(f *fence, newMaster string) error {
+	host, port, err := splitAddr(newMaster)
+	if err == nil {
+		err = g.backend().SlaveOf(f.do, host, port)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("slaveof fenced old master %s to master %s ok", f.addr, newMaster)
+	f.release()
+
+	if _, opts := g.Options(); opts.IsConfigRewrite() {
+		if _, err := f.do("CONFIG", "REWRITE"); err != nil {
+			log.Errorf("config rewrite %s err %v", f.addr, err)
+		}
+	}
+	return nil
+}
+
+// Switchover promotes the slave to master when the old master is still alive,
+// then lets the old master replicate from the new one.
+// If fencing, the writes to the old master are stopped before promoting
+// and the candidate must catch up with it.
+func (g *Group) Switchover(addr string) error {
+	_, opts := g.Options()
+
+	g.m.Lock()
+	oldMaster := g.Master
+	g.m.Unlock()
+
+	var f *fence
+	if len(opts.Fence) > 0 && opts.Fence != FenceNone {
+		var err error
+		f, err = g.fenceAlive(addr, opts.Fence, time.Duration(opts.FenceTimeout)*time.Second)
+		if err != nil {
+			return err
+		}
+		defer f.close()
+	}
+
+	if err := g.promote(addr, true); err != nil {
+		if f != nil {
+			f.release()
+		}
+		return err
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if _, ok := g.backend().(promoter); ok {
+		// the old master becomes a slave of the new master by itself
+		g.Slaves[oldMaster.Addr] = oldMaster
+		return nil
+	}
+
+	host, port, err := splitAddr(addr)
+	if err == nil {
+		err = g.slaveof(oldMaster, host, port)
+	}
+	if err != nil {
+		// keep the fence, the old master is still a master now
+		log.Errorf("slaveof old master %s to master %s err %v", oldMaster.Addr, addr, err)
+		return &DemoteError{Master: oldMaster.Addr, NewMaster: addr, Err: err}
+	}
+
+	log.Infof("slaveof old master %s to master %s ok", oldMaster.Addr, addr)
+	g.Slaves[oldMaster.Addr] = oldMaster
+
+	if f != nil {
+		f.release()
+	}
+	return nil
+}
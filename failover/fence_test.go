@@ -0,0 +1,227 @@
+package failover
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+type fenceTestSuite struct {
+}
+
+var _ = Suite(&fenceTestSuite{})
+
+func (s *fenceTestSuite) TestFenceSwitchover(c *C) {
+	for _, mode := range []string{FencePause, FenceMinReplicas} {
+		rs := newFakeRedisSet()
+
+		master := rs.add(nil)
+		slave := rs.add(master)
+		master.update(func(n *fakeRedis) { n.offset = 100 })
+
+		g := newFakeGroup(c, master, GroupOptions{Fence: mode, FenceTimeout: 2})
+		c.Assert(g.Switchover(slave.Addr), IsNil)
+
+		c.Assert(slave.getMaster(), Equals, "")
+		c.Assert(master.getMaster(), Equals, slave.Addr)
+		c.Assert(g.Master.Addr, Equals, slave.Addr)
+		c.Assert(g.Slaves[master.Addr], NotNil)
+
+		if mode == FencePause {
+			c.Assert(master.commands("CLIENT"), DeepEquals, []string{"CLIENT PAUSE 2000 WRITE", "CLIENT UNPAUSE"})
+		} else {
+			c.Assert(master.commands("CONFIG SET"), DeepEquals, []string{
+				"CONFIG SET min-replicas-to-write " + strconv.Itoa(fenceMinReplicas),
+				"CONFIG SET min-replicas-to-write 0",
+			})
+		}
+		c.Assert(master.getConfig("min-replicas-to-write"), Equals, "0")
+
+		rs.close()
+	}
+}
+
+func (s *fenceTestSuite) TestFenceCatchUpTimeout(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave := rs.add(master)
+	master.update(func(n *fakeRedis) { n.offset = 100 })
+	slave.update(func(n *fakeRedis) { n.noSync = true })
+
+	// redis before 6.2 can't pause writes, fall back to min_replicas
+	master.update(func(n *fakeRedis) {
+		n.hook = func(args []string) (interface{}, bool) {
+			if args[0] == "CLIENT" {
+				return redis.Error("ERR syntax error"), true
+			}
+			return nil, false
+		}
+	})
+
+	g := newFakeGroup(c, master, GroupOptions{Fence: FencePause, FenceTimeout: 2})
+
+	t := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- g.Switchover(slave.Addr) }()
+
+	// the status is not blocked while waiting the slave
+	time.Sleep(200 * time.Millisecond)
+	g.Status()
+	c.Assert(time.Since(t) < 800*time.Millisecond, Equals, true)
+
+	// the slave can't catch up, give up and release the fence
+	err := <-done
+	c.Assert(err, ErrorMatches, fmt.Sprintf("slave %s can't catch up with master %s .*", slave.Addr, master.Addr))
+	c.Assert(slave.getMaster(), Equals, master.Addr)
+	c.Assert(g.Master.Addr, Equals, master.Addr)
+	c.Assert(master.commands("CONFIG SET"), HasLen, 2)
+	c.Assert(master.getConfig("min-replicas-to-write"), Equals, "0")
+}
+
+func (s *fenceTestSuite) TestFenceDownMaster(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	for _, demoted := range []bool{true, false} {
+		master := rs.add(nil)
+		slave := rs.add(master)
+
+		a := newCheckApp(&Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 1})
+		g := addTestGroup(a, master.Addr, GroupOptions{Fence: FenceMinReplicas, FenceTimeout: 2, VerifyTimeout: 1})
+		c.Assert(a.checkMaster(g), Equals, false)
+
+		// only we are partitioned from the master, others can still write to it
+		master.update(func(n *fakeRedis) {
+			n.hook = func(args []string) (interface{}, bool) {
+				switch args[0] {
+				case "ROLE":
+					return redis.Error("ERR partitioned"), true
+				case "SLAVEOF", "REPLICAOF":
+					if !demoted {
+						return redis.Error("ERR partitioned"), true
+					}
+				}
+				return nil, false
+			}
+		})
+		slave.update(func(n *fakeRedis) { n.linkDown = true })
+
+		c.Assert(a.checkMaster(g), Equals, true)
+		newMaster, err := a.doFailover(g, FailoverReasonDown, "", false)
+		g.Release()
+		c.Assert(err, IsNil)
+		c.Assert(newMaster, Equals, slave.Addr)
+		c.Assert(slave.getMaster(), Equals, "")
+
+		records := a.history.Records()
+		c.Assert(records, HasLen, 1)
+
+		if demoted {
+			// the old master follows the new one, and accepts the writes after it is promoted again
+			c.Assert(master.getMaster(), Equals, slave.Addr)
+			c.Assert(master.getConfig("min-replicas-to-write"), Equals, "0")
+			c.Assert(records[0].Fence, Equals, "")
+		} else {
+			// still a master, keep the fence and record it
+			c.Assert(master.getMaster(), Equals, "")
+			c.Assert(master.getConfig("min-replicas-to-write"), Equals, strconv.Itoa(fenceMinReplicas))
+			c.Assert(records[0].Fence, Equals, fmt.Sprintf("min-replicas-to-write %d, was 0", fenceMinReplicas))
+		}
+	}
+}
+
+// busyMaster replies BUSY to the commands, like running a long script.
+func busyMaster(n *fakeRedis, cmds ...string) {
+	n.update(func(n *fakeRedis) {
+		n.hook = func(args []string) (interface{}, bool) {
+			for _, cmd := range cmds {
+				if args[0] == cmd {
+					return redis.Error("BUSY Redis is busy running a script."), true
+				}
+			}
+			return nil, false
+		}
+	})
+}
+
+func (s *fenceTestSuite) TestBusyMaster(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	for _, fence := range []string{FenceNone, FencePause} {
+		master := rs.add(nil)
+		slave := rs.add(master)
+
+		cfg := &Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 1}
+		a := newCheckApp(cfg)
+		g := addTestGroup(a, master.Addr, GroupOptions{Fence: fence, FenceTimeout: 2})
+		c.Assert(a.checkMaster(g), Equals, false)
+
+		master.update(func(n *fakeRedis) { n.replyErr = "BUSY Redis is busy running a script." })
+
+		if fence == FenceNone {
+			// can't fence it, wait approving
+			c.Assert(a.checkMaster(g), Equals, false)
+		} else {
+			// the fence fails, nothing is promoted
+			c.Assert(a.checkMaster(g), Equals, true)
+			a.failoverGroups(cfg, []*Group{g})
+
+			records := a.history.Records()
+			c.Assert(records, HasLen, 1)
+			c.Assert(records[0].Reason, Equals, FailoverReasonUnhealthy)
+			c.Assert(records[0].Error, Matches, "fence master .* BUSY .*")
+		}
+
+		c.Assert(g.pending.Get(), Equals, int32(1))
+		c.Assert(a.masters.GetMasters(), DeepEquals, []string{master.Addr})
+		c.Assert(g.Master.Addr, Equals, master.Addr)
+		c.Assert(slave.getMaster(), Equals, master.Addr)
+		c.Assert(slave.commands("REPLICAOF"), HasLen, 0)
+
+		types := eventTypes(a.events)
+		c.Assert(types[len(types)-1], Equals, EventFailoverPending)
+
+		// don't try again until approved
+		c.Assert(a.checkMaster(g), Equals, false)
+	}
+}
+
+func (s *fenceTestSuite) TestDemoteFailed(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave := rs.add(master)
+
+	cfg := &Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 1}
+	a := newCheckApp(cfg)
+	g := addTestGroup(a, master.Addr, GroupOptions{Fence: FencePause, FenceTimeout: 2, VerifyTimeout: 1})
+	c.Assert(a.checkMaster(g), Equals, false)
+
+	// the master is busy after fenced
+	master.update(func(n *fakeRedis) { n.replyErr = "BUSY Redis is busy running a script." })
+	c.Assert(a.checkMaster(g), Equals, true)
+	master.update(func(n *fakeRedis) { n.replyErr = "" })
+	busyMaster(master, "SLAVEOF", "REPLICAOF")
+
+	a.failoverGroups(cfg, []*Group{g})
+
+	// two masters, but the new one is monitored
+	c.Assert(slave.getMaster(), Equals, "")
+	c.Assert(master.getMaster(), Equals, "")
+	c.Assert(a.masters.GetMasters(), DeepEquals, []string{slave.Addr})
+
+	records := a.history.Records()
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].NewMaster, Equals, slave.Addr)
+	c.Assert(records[0].Error, Matches, fmt.Sprintf("promoted %s, but slaveof old master %s .* is still a master", slave.Addr, master.Addr))
+
+	// the fence is kept
+	c.Assert(master.commands("CLIENT"), DeepEquals, []string{"CLIENT PAUSE 2000 WRITE"})
+}
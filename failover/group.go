@@ -3,9 +3,7 @@ package failover
 import (
 	"errors"
 	"fmt"
-	"net"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,6 +17,7 @@ var (
 	ErrNodeAlive   = errors.New("Node may be still alive")
 	ErrNoCandidate = errors.New("no proper candidate to be promoted to master")
 	ErrNodeType    = errors.New("Node is not the expected type")
+	ErrGroupBusy   = errors.New("group is doing failover now")
 )
 
 const (
@@ -35,13 +34,27 @@ const (
 
 // A node represents a real redis server
 type Node struct {
-	// Redis address, only support tcp now
+	// Redis address, host:port or unix:/path/to/redis.sock
 	Addr string
 
 	// Replication offset
 	Offset int64
 
+	// The slave health probed in the last check
+	Health *ReplicaHealth
+
+	// REPLICAOF or SLAVEOF, detected from the redis version
+	replCmd string
+	// the server doesn't support ROLE, use INFO replication
+	noRole bool
+
 	conn redis.Conn
+	// only used for probing the slave, with short timeout
+	probeConn redis.Conn
+
+	// the IPs the hostname resolved to last time
+	ips          []string
+	resolvedTime time.Time
 }
 
 func (n *Node) String() string {
@@ -53,7 +66,7 @@ func (n *Node) doCommand(cmd string, args ...interface{}) (interface{}, error) {
 	var v interface{}
 	for i := 0; i < 3; i++ {
 		if n.conn == nil {
-			n.conn, err = redis.DialTimeout("tcp", n.Addr, 5*time.Second, 0, 0)
+			n.conn, err = dialAddr(n.Addr, 5*time.Second, 0, 0)
 			if err != nil {
 				log.Errorf("dial %s error: %v, try again", n.Addr, err)
 				continue
@@ -62,7 +75,10 @@ func (n *Node) doCommand(cmd string, args ...interface{}) (interface{}, error) {
 		}
 
 		v, err = n.conn.Do(cmd, args...)
-		if err != nil {
+		if _, ok := err.(redis.Error); ok {
+			// the server replies an error, no need to retry
+			return nil, err
+		} else if err != nil {
 			log.Errorf("do %s command for %s error: %v, try again", cmd, n.Addr, err)
 			n.conn.Close()
 			n.conn = nil
@@ -76,45 +92,31 @@ func (n *Node) doCommand(cmd string, args ...interface{}) (interface{}, error) {
 	return nil, err
 }
 
-func (n *Node) doRole() ([]interface{}, error) {
-	return redis.Values(n.doCommand("ROLE"))
-}
-
 func (n *Node) ping() error {
 	_, err := n.doCommand("PING")
 	return err
 }
 
-func (n *Node) slaveof(host string, port string) error {
-	_, err := n.doCommand("SLAVEOF", host, port)
+// configRewrite saves the replication change to the config file, so it survives restart.
+func (n *Node) configRewrite() error {
+	_, err := n.doCommand("CONFIG", "REWRITE")
 	return err
 }
 
-func (n *Node) doRelpInfo() (map[string]string, error) {
-	v, err := redis.String(n.doCommand("INFO", "REPLICATION"))
-	if err != nil {
-		return nil, err
-	}
-
-	seps := strings.Split(v, "\r\n")
-	// skip first line, is # Replication
-	seps = seps[1:]
-
-	m := make(map[string]string, len(seps))
-	for _, s := range seps {
-		kv := strings.SplitN(s, ":", 2)
-		if len(kv) == 2 {
-			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
-		}
-	}
-	return m, nil
-}
-
 func (n *Node) close() {
+	// the server may be upgraded when we connect it again
+	n.replCmd = ""
+	n.noRole = false
+
 	if n.conn != nil {
 		n.conn.Close()
 		n.conn = nil
 	}
+
+	if n.probeConn != nil {
+		n.probeConn.Close()
+		n.probeConn = nil
+	}
 }
 
 // A group contains a Redis master and one or more slaves
@@ -126,6 +128,34 @@ type Group struct {
 
 	CheckErrNum sync2.AtomicInt32
 
+	events *eventHub
+
+	// 1 if doing failover or switchover
+	busy sync2.AtomicInt32
+
+	// 1 if the master is down but auto failover is disabled
+	pending sync2.AtomicInt32
+
+	// 1 if the master is down and we have observed the failover in observe only mode
+	observed sync2.AtomicInt32
+
+	// the reason why we skipped the failover for the down master
+	skipped sync2.AtomicString
+
+	om sync.Mutex
+	// options saved in cluster and the effective options with global config
+	rawOpts GroupOptions
+	opts    GroupOptions
+
+	// only used in app check loop
+	lastCheck time.Time
+
+	// the last check error, protected by m
+	checkErr error
+
+	// the slots the new master must own after redis cluster failover, protected by m
+	slots string
+
 	m sync.Mutex
 }
 
@@ -153,47 +183,143 @@ func (g *Group) Check() error {
 	g.m.Lock()
 	defer g.m.Unlock()
 
+	now := time.Now()
+	g.Master.resolve(now)
+	for _, slave := range g.Slaves {
+		slave.resolve(now)
+	}
+
 	err := g.doRole()
+	if err == nil {
+		// the slaves are only probed when the master is alive,
+		// so we still know their health before the master is down.
+		g.checkSlaves()
+
+		err = g.doProbes()
+	}
+
+	g.checkErr = err
 	if err != nil {
 		g.CheckErrNum.Add(1)
 	} else {
 		g.CheckErrNum.Set(0)
+		g.pending.Set(0)
+		g.observed.Set(0)
+		g.skipped.Set("")
 	}
 
 	return err
 }
 
+// downTime returns how long the master is down, estimated by the failed checks.
+func (g *Group) downTime() time.Duration {
+	_, opts := g.Options()
+	return time.Duration(g.CheckErrNum.Get()) * time.Duration(opts.CheckInterval) * time.Millisecond
+}
+
+// SeedSlaves adds the known slaves if we haven't found any with ROLE,
+// so we can still elect one if the master is down before the first check.
+func (g *Group) SeedSlaves(addrs []string) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if len(g.Slaves) > 0 {
+		return
+	}
+
+	for _, addr := range addrs {
+		if addr != g.Master.Addr {
+			g.Slaves[addr] = &Node{Addr: addr}
+		}
+	}
+}
+
+// SlaveAddrs returns the master and the sorted slaves.
+func (g *Group) SlaveAddrs() (string, []string) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	slaves := make([]string, 0, len(g.Slaves))
+	for addr := range g.Slaves {
+		slaves = append(slaves, addr)
+	}
+	sort.Strings(slaves)
+	return g.Master.Addr, slaves
+}
+
+// CheckError returns the error of the last check.
+func (g *Group) CheckError() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	return g.checkErr
+}
+
+func (g *Group) SetOptions(raw GroupOptions, effective GroupOptions) {
+	g.om.Lock()
+	defer g.om.Unlock()
+
+	g.rawOpts = raw
+	g.opts = effective
+}
+
+// Options returns the options saved in cluster and the effective options.
+func (g *Group) Options() (GroupOptions, GroupOptions) {
+	g.om.Lock()
+	defer g.om.Unlock()
+
+	return g.rawOpts, g.opts
+}
+
+// due returns true if it is time to check the group.
+func (g *Group) due(now time.Time, tick time.Duration) bool {
+	_, opts := g.Options()
+	interval := time.Duration(opts.CheckInterval) * time.Millisecond
+
+	if now.Sub(g.lastCheck) < interval-tick/2 {
+		return false
+	}
+
+	g.lastCheck = now
+	return true
+}
+
+// backend returns the backend of the group, chosen by the options.
+func (g *Group) backend() Backend {
+	_, opts := g.Options()
+	return getBackend(opts.Backend)
+}
+
 func (g *Group) doRole() error {
-	v, err := g.Master.doRole()
+	r, err := g.role(g.Master)
 	if err != nil {
-		return ErrNodeDown
+		return replyError(err)
 	}
 
-	// the first line is server type
-	serverType, _ := redis.String(v[0], nil)
-	if serverType != MasterType {
+	if r.Type != MasterType {
 		log.Errorf("server %s is not master now", g.Master.Addr)
 		return ErrNodeType
 	}
 
-	// second is master replication offset,
-	g.Master.Offset, _ = redis.Int64(v[1], nil)
+	g.Master.Offset = r.Offset
 
-	// then slave list [host, port, offset]
-	slaves, _ := redis.Values(v[2], nil)
-	nodes := make(map[string]*Node, len(slaves))
-	for i := 0; i < len(slaves); i++ {
-		ss, _ := redis.Strings(slaves[i], nil)
-		var n Node
-		n.Addr = fmt.Sprintf("%s:%s", ss[0], ss[1])
-		n.Offset, _ = strconv.ParseInt(fmt.Sprintf("%s", ss[2]), 10, 64)
-		nodes[n.Addr] = &n
+	nodes := make(map[string]*Node, len(r.Slaves))
+	for _, s := range r.Slaves {
+		// keep the connections and health of the known slave,
+		// and its hostname if the master reports it with IP
+		n := g.findSlave(s.Addr)
+		if n == nil {
+			n = &Node{Addr: s.Addr}
+		}
+		n.Offset = s.Offset
+		nodes[n.Addr] = n
 	}
 
 	// we don't care slave add or remove too much, so only log
 	for addr := range nodes {
 		if _, ok := g.Slaves[addr]; !ok {
 			log.Infof("slave %s added", addr)
+			g.events.Publish(&Event{Type: EventSlaveAdded, Master: g.Master.Addr, Node: addr})
 		}
 	}
 
@@ -201,6 +327,7 @@ func (g *Group) doRole() error {
 		if _, ok := nodes[addr]; !ok {
 			log.Infof("slave %s removed", addr)
 			slave.close()
+			g.events.Publish(&Event{Type: EventSlaveRemoved, Master: g.Master.Addr, Node: addr})
 		}
 	}
 
@@ -208,6 +335,69 @@ func (g *Group) doRole() error {
 	return nil
 }
 
+// findSlave returns the known slave which is the addr.
+func (g *Group) findSlave(addr string) *Node {
+	if n, ok := g.Slaves[addr]; ok {
+		return n
+	}
+
+	for _, n := range g.Slaves {
+		if n.matches(addr) {
+			return n
+		}
+	}
+	return nil
+}
+
+// Acquire marks the group doing failover, returns false if other is doing.
+func (g *Group) Acquire() bool {
+	return g.busy.CompareAndSwap(0, 1)
+}
+
+func (g *Group) Release() {
+	g.busy.Set(0)
+}
+
+type NodeStatus struct {
+	Addr   string         `json:"addr"`
+	Offset int64          `json:"offset"`
+	Health *ReplicaHealth `json:"health,omitempty"`
+}
+
+type GroupStatus struct {
+	Master      NodeStatus   `json:"master"`
+	Slaves      []NodeStatus `json:"slaves"`
+	CheckErrNum int32        `json:"check_err_num"`
+	CheckError  string       `json:"check_error,omitempty"`
+	Pending     bool         `json:"pending_failover"`
+	Options     GroupOptions `json:"options"`
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+	// can't do failover before the time
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	// the slots of the redis cluster shard master
+	Slots string `json:"slots,omitempty"`
+}
+
+func (g *Group) Status() GroupStatus {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	var s GroupStatus
+	s.Master = NodeStatus{Addr: g.Master.Addr, Offset: g.Master.Offset}
+	s.Slaves = make([]NodeStatus, 0, len(g.Slaves))
+	for _, slave := range g.Slaves {
+		s.Slaves = append(s.Slaves, NodeStatus{Addr: slave.Addr, Offset: slave.Offset, Health: slave.Health})
+	}
+	sort.Slice(s.Slaves, func(i, j int) bool { return s.Slaves[i].Addr < s.Slaves[j].Addr })
+	s.CheckErrNum = g.CheckErrNum.Get()
+	if g.checkErr != nil {
+		s.CheckError = g.checkErr.Error()
+	}
+	s.Pending = g.pending.Get() == 1
+	_, s.Options = g.Options()
+	return s
+}
+
 func (g *Group) Ping() error {
 	g.m.Lock()
 	defer g.m.Unlock()
@@ -215,35 +405,86 @@ func (g *Group) Ping() error {
 	return g.Master.ping()
 }
 
+// Candidate is the elected slave and why it is elected.
+type Candidate struct {
+	Addr     string `json:"addr"`
+	Priority int    `json:"priority"`
+	Offset   int64  `json:"offset"`
+	Reason   string `json:"reason"`
+}
+
 // Elect a best slave which has the most up-to-date data with master
 func (g *Group) Elect() (string, error) {
+	c, err := g.ElectCandidate()
+	if err != nil {
+		return "", err
+	}
+	return c.Addr, nil
+}
+
+// ElectCandidate is like Elect, but returns the candidate detail.
+func (g *Group) ElectCandidate() (*Candidate, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	return g.elect(false)
+}
+
+// ElectAlive elects a best slave for switchover, the master is still alive.
+func (g *Group) ElectAlive() (string, error) {
 	g.m.Lock()
 	defer g.m.Unlock()
 
+	c, err := g.elect(true)
+	if err != nil {
+		return "", err
+	}
+	return c.Addr, nil
+}
+
+func (g *Group) elect(masterAlive bool) (*Candidate, error) {
 	var addr string
 	var checkOffset int64 = 0
 	var checkPriority int = 0
 
+	_, opts := g.Options()
+	byOffset := opts.ElectStrategy == ElectByOffset
+
+	b := g.backend()
+
+	checked := 0
 	for _, slave := range g.Slaves {
-		m, err := slave.doRelpInfo()
+		info, err := b.ReplInfo(slave.doCommand)
 		if err != nil {
 			log.Infof("slave %s get replication info err %v, skip it", slave.Addr, err)
 			continue
 		}
 
-		if m["slave"] == MasterType {
+		if info.Role == MasterType {
 			log.Errorf("server %s is not slave now, skip it", slave.Addr)
 			continue
 		}
 
-		if m["master_link_status"] == "up" {
+		if h := slave.Health; h != nil && !h.Healthy {
+			log.Errorf("slave %s is unhealthy before, %s, skip it", slave.Addr, h.Error)
+			continue
+		}
+
+		if info.LinkUp && !masterAlive {
 			log.Infof("slave %s master_link_status is up, master %s may be not down???",
 				slave.Addr, g.Master.Addr)
-			return "", ErrNodeAlive
+			return nil, ErrNodeAlive
 		}
 
-		priority, _ := strconv.Atoi(m["slave_priority"])
-		replOffset, _ := strconv.ParseInt(m["slave_repl_offset"], 10, 64)
+		checked++
+
+		priority := info.Priority
+		replOffset := info.Offset
+
+		if byOffset {
+			// ignore priority
+			priority = 0
+		}
 
 		used := false
 		// like redis-sentinel, first check priority, then salve repl offset
@@ -264,22 +505,277 @@ func (g *Group) Elect() (string, error) {
 
 	if len(addr) == 0 {
 		log.Errorf("no proper candidate to be promoted")
-		return "", ErrNoCandidate
+		return nil, ErrNoCandidate
 	}
 
 	log.Infof("select slave %s as new master, priority:%d, repl_offset:%d", addr, checkPriority, checkOffset)
 
-	return addr, nil
+	c := &Candidate{
+		Addr:     addr,
+		Priority: checkPriority,
+		Offset:   checkOffset,
+	}
+
+	if byOffset {
+		c.Reason = fmt.Sprintf("max repl_offset %d in %d slaves", checkOffset, checked)
+	} else {
+		c.Reason = fmt.Sprintf("max priority %d, then max repl_offset %d in %d slaves", checkPriority, checkOffset, checked)
+	}
+
+	return c, nil
+}
+
+// DataLossError means promoting the candidate would lose too much data.
+type DataLossError struct {
+	Candidate string
+	Loss      int64
+	MaxLoss   int64
+}
+
+func (e *DataLossError) Error() string {
+	return fmt.Sprintf("promoting %s would lose %d bytes, more than max_data_loss %d, force the failover to promote it",
+		e.Candidate, e.Loss, e.MaxLoss)
+}
+
+// CatchUp compares the offsets of all reachable slaves, if some are ahead of the candidate,
+// lets the candidate replicate from the most up-to-date one and waits at most timeout.
+// Returns the bytes the candidate is behind the most up-to-date node we know,
+// or a DataLossError if it is more than maxLoss, 0 means no limit.
+func (g *Group) CatchUp(addr string, timeout time.Duration, maxLoss int64) (int64, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	node := g.Slaves[addr]
+	if node == nil {
+		return 0, fmt.Errorf("%s is not the slave of master %s", addr, g.Master.Addr)
+	}
+
+	offset, err := g.replOffset(node)
+	if err != nil {
+		return 0, err
+	}
+
+	// the master offset is got in the last check, the slaves may be ahead of it
+	var best *Node
+	bestOffset := g.Master.Offset
+	slaveOffset := offset
+	for _, slave := range g.Slaves {
+		if slave == node {
+			continue
+		}
+
+		o, err := g.replOffset(slave)
+		if err != nil {
+			log.Infof("slave %s get replication offset err %v, skip it", slave.Addr, err)
+			continue
+		}
+
+		if o > slaveOffset {
+			best = slave
+			slaveOffset = o
+		}
+
+		if o > bestOffset {
+			bestOffset = o
+		}
+	}
+
+	if best != nil && timeout > 0 {
+		offset = g.catchUp(node, best, offset, timeout)
+	}
+
+	loss := bestOffset - offset
+	if loss < 0 {
+		loss = 0
+	}
+
+	if maxLoss > 0 && loss > maxLoss {
+		if best != nil && timeout > 0 {
+			// give up, let the candidate replicate from the old master again
+			host, port, err := splitAddr(g.Master.Addr)
+			if err == nil {
+				err = g.slaveof(node, host, port)
+			}
+			if err != nil {
+				log.Errorf("slaveof %s to old master %s err %v", node.Addr, g.Master.Addr, err)
+			}
+		}
+		return loss, &DataLossError{Candidate: addr, Loss: loss, MaxLoss: maxLoss}
+	}
+
+	return loss, nil
+}
+
+// catchUp lets the node replicate from the source until it reaches the source offset,
+// returns the offset the node reached. The caller must hold the group lock,
+// it is released while waiting, the caller must acquire the group so nobody changes it.
+func (g *Group) catchUp(node *Node, source *Node, offset int64, timeout time.Duration) int64 {
+	target, err := g.replOffset(source)
+	if err != nil {
+		return offset
+	}
+
+	log.Infof("slave %s offset %d is behind slave %s offset %d, catch up", node.Addr, offset, source.Addr, target)
+
+	host, port, err := splitAddr(source.Addr)
+	if err == nil {
+		err = g.slaveof(node, host, port)
+	}
+	if err != nil {
+		log.Errorf("slaveof %s to %s for catching up err %v", node.Addr, source.Addr, err)
+		return offset
+	}
+
+	// don't block the check and status while waiting
+	g.m.Unlock()
+	offset, ok := g.waitOffset(node.Addr, target, timeout)
+	g.m.Lock()
+
+	if ok {
+		log.Infof("slave %s caught up with slave %s, offset %d", node.Addr, source.Addr, offset)
+	} else {
+		log.Errorf("slave %s catch up with slave %s timeout, offset %d < %d", node.Addr, source.Addr, offset, target)
+	}
+	return offset
+}
+
+// replOffset returns the replication offset the slave has processed.
+func (g *Group) replOffset(n *Node) (int64, error) {
+	info, err := g.backend().ReplInfo(n.doCommand)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Offset, nil
+}
+
+// waitOffset waits the slave to reach the target offset, returns the offset it reached.
+// It uses its own connection, so the caller needn't hold the group lock.
+func (g *Group) waitOffset(addr string, target int64, timeout time.Duration) (int64, bool) {
+	b := g.backend()
+
+	var conn redis.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	var offset int64
+	deadline := time.Now().Add(timeout)
+	for {
+		if conn == nil {
+			conn, _ = dialAddr(addr, time.Second, time.Second, time.Second)
+		}
+
+		if conn != nil {
+			if info, err := b.ReplInfo(conn.Do); err != nil {
+				conn.Close()
+				conn = nil
+			} else if offset = info.Offset; offset >= target {
+				return offset, true
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return offset, false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// slaveof lets the node replicate from host:port, or be a master with "no one",
+// then rewrites the config file if needed.
+func (g *Group) slaveof(n *Node, host string, port string) error {
+	if err := g.replicaOf(n, host, port); err != nil {
+		return err
+	}
+
+	if _, opts := g.Options(); opts.IsConfigRewrite() {
+		if err := n.configRewrite(); err != nil {
+			// the redis may run without a config file, only log
+			log.Errorf("config rewrite %s err %v", n.Addr, err)
+		}
+	}
+
+	return nil
+}
+
+// replicaOf lets the backend change the replication of the node, for redis the command
+// detected from the version is cached in the node, so we needn't INFO SERVER every time.
+func (g *Group) replicaOf(n *Node, host string, port string) error {
+	b := g.backend()
+
+	r, ok := b.(redisBackend)
+	if !ok {
+		return b.SlaveOf(n.doCommand, host, port)
+	}
+
+	cmd := n.replCmd
+	if len(cmd) == 0 {
+		var err error
+		if cmd, err = r.replicaOfCommand(n.doCommand); err == nil {
+			n.replCmd = cmd
+		}
+	}
+
+	_, err := n.doCommand(cmd, host, port)
+	return err
+}
+
+// role returns the role of the node, for redis the server without ROLE is remembered
+// in the node, so we needn't send the failing ROLE every time.
+func (g *Group) role(n *Node) (*RoleInfo, error) {
+	b := g.backend()
+
+	r, ok := b.(redisBackend)
+	if !ok {
+		return b.Role(n.doCommand)
+	}
+
+	if n.noRole {
+		return r.infoRole(n.doCommand)
+	}
+
+	v, err := redis.Values(n.doCommand("ROLE"))
+	if err == nil {
+		return parseRole(v)
+	}
+
+	if !unknownCommand(err) {
+		return nil, err
+	}
+
+	n.noRole = true
+	return r.infoRole(n.doCommand)
 }
 
 // Promote the slave to master, then let other slaves replicate from it
 func (g *Group) Promote(addr string) error {
+	return g.promote(addr, false)
+}
+
+func (g *Group) promote(addr string, masterAlive bool) error {
 	g.m.Lock()
 	defer g.m.Unlock()
 
 	node := g.Slaves[addr]
+	if node == nil {
+		return fmt.Errorf("%s is not the slave of master %s", addr, g.Master.Addr)
+	}
+
+	if p, ok := g.backend().(promoter); ok {
+		return g.promoteBy(p, node, masterAlive)
+	}
+
+	// other slaves must replicate from it
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return err
+	}
 
-	if err := node.slaveof("no", "one"); err != nil {
+	if err := g.slaveof(node, "no", "one"); err != nil {
 		return err
 	}
 
@@ -287,9 +783,8 @@ func (g *Group) Promote(addr string) error {
 
 	g.Master = node
 
-	host, port, _ := net.SplitHostPort(addr)
 	for _, slave := range g.Slaves {
-		if err := slave.slaveof(host, port); err != nil {
+		if err := g.slaveof(slave, host, port); err != nil {
 			// if we go here, the replication topology may be wrong
 			// so use fatal level and we should fix it manually
 			log.Fatalf("slaveof %s to master %s err %v", slave.Addr, addr, err)
@@ -300,3 +795,150 @@ func (g *Group) Promote(addr string) error {
 
 	return nil
 }
+
+// promoteBy lets the backend promote the slave, the other slaves follow it by themselves.
+func (g *Group) promoteBy(p promoter, node *Node, masterAlive bool) error {
+	slots, err := p.MasterSlots(node.doCommand)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Promote(node.doCommand, masterAlive); err != nil {
+		return err
+	}
+
+	log.Infof("promote %s to master with slots %s ok", node.Addr, slots)
+
+	delete(g.Slaves, node.Addr)
+	g.Master = node
+	g.slots = slots
+	return nil
+}
+
+// SlaveHealth is the replication state of a slave after failover.
+type SlaveHealth struct {
+	Addr   string `json:"addr"`
+	LinkUp bool   `json:"link_up"`
+	// how many times we re-issued SLAVEOF for it
+	Resent int    `json:"resent,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TopologyHealth is the replication topology verified after failover.
+type TopologyHealth struct {
+	Healthy         bool          `json:"healthy"`
+	Master          string        `json:"master"`
+	ConnectedSlaves int           `json:"connected_slaves"`
+	Slaves          []SlaveHealth `json:"slaves"`
+	// the slots the redis cluster shard master owns
+	Slots string `json:"slots,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Verify polls the master and slaves until all slaves replicate from the master with the link up
+// and the master sees all of them, or the timeout. The slave replicating from the wrong master
+// is told SLAVEOF again.
+func (g *Group) Verify(timeout time.Duration) *TopologyHealth {
+	g.m.Lock()
+	h := &TopologyHealth{Master: g.Master.Addr}
+	slaves := make(map[string]*SlaveHealth, len(g.Slaves))
+	for addr := range g.Slaves {
+		slaves[addr] = &SlaveHealth{Addr: addr}
+	}
+	g.m.Unlock()
+
+	host, port, err := splitAddr(h.Master)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		// hold the lock for one round only, so the status isn't blocked while waiting
+		g.m.Lock()
+		g.verifyOnce(h, slaves, host, port)
+		g.m.Unlock()
+
+		if h.Healthy || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	h.Slaves = make([]SlaveHealth, 0, len(slaves))
+	for _, s := range slaves {
+		h.Slaves = append(h.Slaves, *s)
+	}
+	sort.Slice(h.Slaves, func(i, j int) bool { return h.Slaves[i].Addr < h.Slaves[j].Addr })
+
+	return h
+}
+
+// verifyOnce checks the master and slaves once and updates the health, the caller must hold g.m.
+func (g *Group) verifyOnce(h *TopologyHealth, slaves map[string]*SlaveHealth, host string, port string) {
+	b := g.backend()
+	// the redis cluster slaves follow the new master by themselves
+	p, follow := b.(promoter)
+
+	linkUp := 0
+	for addr, slave := range g.Slaves {
+		s, ok := slaves[addr]
+		if !ok {
+			// found by the check while waiting
+			s = &SlaveHealth{Addr: addr}
+			slaves[addr] = s
+		}
+
+		if s.LinkUp {
+			linkUp++
+			continue
+		}
+
+		info, err := b.ReplInfo(slave.doCommand)
+		if err != nil {
+			s.Error = err.Error()
+			continue
+		}
+		s.Error = ""
+
+		if info.Role != SlaveType || info.MasterHost != host || info.MasterPort != port {
+			if follow {
+				s.Error = fmt.Sprintf("replicates from %s:%s", info.MasterHost, info.MasterPort)
+				continue
+			}
+
+			log.Errorf("slave %s replicates from %s:%s, not master %s, slaveof again",
+				addr, info.MasterHost, info.MasterPort, h.Master)
+			s.Resent++
+			if err := g.slaveof(slave, host, port); err != nil {
+				s.Error = err.Error()
+			}
+			continue
+		}
+
+		if info.LinkUp {
+			s.LinkUp = true
+			linkUp++
+		}
+	}
+
+	if r, err := g.role(g.Master); err != nil {
+		h.Error = err.Error()
+	} else {
+		h.Error = ""
+		h.ConnectedSlaves = len(r.Slaves)
+	}
+
+	if follow && len(h.Error) == 0 && len(g.slots) > 0 {
+		var err error
+		if h.Slots, err = p.MasterSlots(g.Master.doCommand); err != nil {
+			h.Error = err.Error()
+		} else if h.Slots != g.slots {
+			h.Error = fmt.Sprintf("owns slots %q, not %q", h.Slots, g.slots)
+		}
+	}
+
+	h.Healthy = len(h.Error) == 0 && linkUp == len(g.Slaves) && h.ConnectedSlaves >= len(g.Slaves)
+}
@@ -0,0 +1,502 @@
+package failover
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+type groupTestSuite struct {
+}
+
+var _ = Suite(&groupTestSuite{})
+
+func (s *groupTestSuite) TestReplicaOfCommand(c *C) {
+	c.Assert(replicaOfCommand("2.8.19"), Equals, "SLAVEOF")
+	c.Assert(replicaOfCommand("4.0.14"), Equals, "SLAVEOF")
+	c.Assert(replicaOfCommand("5.0.0"), Equals, "REPLICAOF")
+	c.Assert(replicaOfCommand("7.2.4"), Equals, "REPLICAOF")
+	c.Assert(replicaOfCommand(""), Equals, "SLAVEOF")
+}
+
+func (s *groupTestSuite) TestReplicaHealth(c *C) {
+	info := &ReplInfo{
+		Role:          SlaveType,
+		LinkUp:        true,
+		LastIOSeconds: 3,
+		Offset:        900,
+	}
+
+	opts := GroupOptions{ReplicaMaxLag: 200, ReplicaMaxLagTime: 5}
+
+	h := replicaHealth(info, 1000, &opts)
+	c.Assert(h.Healthy, Equals, true)
+	c.Assert(h.LagBytes, Equals, int64(100))
+	c.Assert(h.LagSeconds, Equals, 3)
+
+	h = replicaHealth(info, 2000, &opts)
+	c.Assert(h.Healthy, Equals, false)
+
+	info.LastIOSeconds = 10
+	h = replicaHealth(info, 1000, &opts)
+	c.Assert(h.Healthy, Equals, false)
+
+	info.LinkUp = false
+	h = replicaHealth(info, 1000, &GroupOptions{})
+	c.Assert(h.Healthy, Equals, false)
+	c.Assert(h.Error, Equals, "master link is down")
+}
+
+func (s *groupTestSuite) TestRedisReplInfo(c *C) {
+	do := func(cmd string, args ...interface{}) (interface{}, error) {
+		return []byte("# Replication\r\nrole:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:6379\r\n" +
+			"master_link_status:up\r\nmaster_last_io_seconds_ago:1\r\nmaster_sync_in_progress:0\r\n" +
+			"slave_repl_offset:1234\r\nslave_priority:50\r\n"), nil
+	}
+
+	info, err := redisBackend{}.ReplInfo(do)
+	c.Assert(err, IsNil)
+	c.Assert(*info, DeepEquals, ReplInfo{
+		Role:          SlaveType,
+		MasterHost:    "127.0.0.1",
+		MasterPort:    "6379",
+		LinkUp:        true,
+		Offset:        1234,
+		Priority:      50,
+		LastIOSeconds: 1,
+	})
+}
+
+func (s *groupTestSuite) TestInfoRole(c *C) {
+	do := func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "ROLE" {
+			return nil, redis.Error("ERR unknown command 'ROLE'")
+		}
+		return []byte("# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+			"slave0:ip=127.0.0.1,port=6380,state=online,offset=100,lag=0\r\n" +
+			"slave1:127.0.0.1,6381,online\r\nmaster_repl_offset:120\r\n"), nil
+	}
+
+	r, err := redisBackend{}.Role(do)
+	c.Assert(err, IsNil)
+	c.Assert(r.Type, Equals, MasterType)
+	c.Assert(r.Offset, Equals, int64(120))
+	c.Assert(r.Slaves, DeepEquals, []NodeStatus{
+		{Addr: "127.0.0.1:6380", Offset: 100},
+		{Addr: "127.0.0.1:6381"},
+	})
+
+	r = parseInfoRole(map[string]string{"role": "slave"})
+	c.Assert(r.Type, Equals, SlaveType)
+
+	c.Assert(unknownCommand(redis.Error("ERR unknown command `ROLE`, with args beginning with: ")), Equals, true)
+	c.Assert(unknownCommand(redis.Error("LOADING Redis is loading the dataset in memory")), Equals, false)
+	c.Assert(unknownCommand(io.EOF), Equals, false)
+}
+
+func (s *groupTestSuite) TestLedisRole(c *C) {
+	master := []interface{}{
+		[]byte("master"),
+		int64(120),
+		[]interface{}{
+			[]interface{}{[]byte("127.0.0.1"), []byte("6381"), []byte("100")},
+			[]interface{}{[]byte("127.0.0.1"), int64(6382), int64(120)},
+		},
+	}
+
+	r, err := parseRole(master)
+	c.Assert(err, IsNil)
+	c.Assert(r.Type, Equals, MasterType)
+	c.Assert(r.Offset, Equals, int64(120))
+	c.Assert(r.Slaves, DeepEquals, []NodeStatus{
+		{Addr: "127.0.0.1:6381", Offset: 100},
+		{Addr: "127.0.0.1:6382", Offset: 120},
+	})
+
+	slave := []interface{}{[]byte("slave"), []byte("127.0.0.1"), int64(6380), []byte("connected"), int64(100)}
+	info, err := parseLedisSlaveRole(slave)
+	c.Assert(err, IsNil)
+	c.Assert(info.Role, Equals, SlaveType)
+	c.Assert(info.MasterHost, Equals, "127.0.0.1")
+	c.Assert(info.MasterPort, Equals, "6380")
+	c.Assert(info.LinkUp, Equals, true)
+	c.Assert(info.Syncing, Equals, false)
+	c.Assert(info.Offset, Equals, int64(100))
+
+	slave[3] = []byte("sync")
+	info, err = parseLedisSlaveRole(slave)
+	c.Assert(err, IsNil)
+	c.Assert(info.LinkUp, Equals, false)
+	c.Assert(info.Syncing, Equals, true)
+
+	_, err = parseLedisSlaveRole(slave[:3])
+	c.Assert(err, NotNil)
+}
+
+func (s *groupTestSuite) TestLedisOptions(c *C) {
+	cfg := &Config{Fence: FencePause, Probes: "ping,canary"}
+
+	o := GroupOptions{Backend: BackendLedis}.effective(cfg, HooksConfig{})
+	c.Assert(o.Fence, Equals, FenceNone)
+	c.Assert(o.Probes, Equals, ProbePing)
+
+	o = GroupOptions{}.effective(cfg, HooksConfig{})
+	c.Assert(o.Backend, Equals, BackendRedis)
+	c.Assert(o.Fence, Equals, FencePause)
+
+	o = GroupOptions{Backend: BackendLedis, Fence: FencePause, Probes: ProbePersistence}
+	c.Assert(o.validate(), HasLen, 2)
+
+	o = GroupOptions{Backend: "memcached"}
+	c.Assert(o.validate(), HasLen, 1)
+}
+
+func (s *groupTestSuite) TestProbes(c *C) {
+	ps, err := parseProbes("ping, canary,,persistence")
+	c.Assert(err, IsNil)
+	c.Assert(ps, DeepEquals, []string{ProbePing, ProbeCanary, ProbePersistence})
+
+	_, err = parseProbes("ping,info")
+	c.Assert(err, NotNil)
+
+	c.Assert(replyError(redis.Error("LOADING Redis is loading the dataset in memory")), Equals, ErrNodeLoading)
+	c.Assert(replyError(redis.Error("BUSY Redis is busy running a script")), Equals, ErrNodeBusy)
+	c.Assert(replyError(redis.Error("MASTERDOWN Link with MASTER is down")), Equals, ErrMasterDown)
+	c.Assert(replyError(io.EOF), Equals, ErrNodeDown)
+
+	c.Assert(masterReachable(ErrNodeBusy), Equals, true)
+	c.Assert(masterReachable(ErrNodeDown), Equals, false)
+}
+
+func (s *groupTestSuite) TestAddr(c *C) {
+	c.Assert(validateNodeAddr("unix:/tmp/redis.sock"), IsNil)
+	c.Assert(validateNodeAddr("unix:redis.sock"), NotNil)
+	c.Assert(validateNodeAddr("redis-0.redis:6379"), IsNil)
+	c.Assert(validateNodeAddr("redis-0.redis"), NotNil)
+
+	_, _, err := splitAddr("unix:/tmp/redis.sock")
+	c.Assert(err, NotNil)
+
+	host, port, err := splitAddr("redis-0.redis:6379")
+	c.Assert(err, IsNil)
+	c.Assert(host, Equals, "redis-0.redis")
+	c.Assert(port, Equals, "6379")
+
+	n := &Node{Addr: "redis-0.redis:6379", ips: []string{"10.0.0.1"}}
+	c.Assert(n.matches("redis-0.redis:6379"), Equals, true)
+	c.Assert(n.matches("10.0.0.1:6379"), Equals, true)
+	c.Assert(n.matches("10.0.0.1:6380"), Equals, false)
+	c.Assert(n.matches("10.0.0.2:6379"), Equals, false)
+
+	// the IP changed, re-resolve it
+	n = &Node{Addr: "localhost:6379", ips: []string{"10.0.0.1"}}
+	n.resolve(time.Now())
+	c.Assert(n.matches("127.0.0.1:6379"), Equals, true)
+	c.Assert(n.matches("10.0.0.1:6379"), Equals, false)
+
+	g := newGroup("127.0.0.1:6379")
+	g.Slaves[n.Addr] = n
+	c.Assert(g.findSlave("127.0.0.1:6379"), Equals, n)
+	c.Assert(g.findSlave("127.0.0.1:6380"), IsNil)
+}
+
+const testClusterNodes = "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected\n" +
+	"67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922\n" +
+	"292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383 [16000->-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]\n" +
+	"6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:30005@31005 master,fail - 0 1426238316232 5 connected\n" +
+	"e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001,redis-1 myself,master - 0 0 1 connected 0-5460\n"
+
+func (s *groupTestSuite) TestClusterNodes(c *C) {
+	nodes := parseClusterNodes(testClusterNodes)
+	c.Assert(nodes, HasLen, 5)
+	c.Assert(nodes[2].Slots, DeepEquals, []string{"10923-16383"})
+	c.Assert(clusterMyself(nodes).Addr, Equals, "127.0.0.1:30001")
+
+	c.Assert(clusterMasters(nodes), DeepEquals, map[string]string{
+		"127.0.0.1:30001": "0-5460",
+		"127.0.0.1:30002": "5461-10922",
+		"127.0.0.1:30003": "10923-16383",
+	})
+
+	var replicated []interface{}
+	do := func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "CLUSTER" && args[0] == "NODES" {
+			return []byte(testClusterNodes), nil
+		}
+		replicated = args
+		return "OK", nil
+	}
+
+	b := clusterBackend{}
+
+	slots, err := b.MasterSlots(do)
+	c.Assert(err, IsNil)
+	c.Assert(slots, Equals, "0-5460")
+
+	c.Assert(b.SlaveOf(do, "127.0.0.1", "30002"), IsNil)
+	c.Assert(replicated, DeepEquals, []interface{}{"REPLICATE", "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"})
+
+	c.Assert(b.SlaveOf(do, "no", "one"), Equals, ErrClusterSlaveOf)
+	c.Assert(b.SlaveOf(do, "127.0.0.1", "30009"), NotNil)
+
+	o := GroupOptions{Backend: BackendCluster}.effective(&Config{Fence: FencePause, CatchUpTimeout: 5, Probes: "ping,canary,persistence"}, HooksConfig{})
+	c.Assert(o.Fence, Equals, FenceNone)
+	c.Assert(o.CatchUpTimeout, Equals, 0)
+	// the canary key is MOVED on the shards not owning it
+	c.Assert(o.Probes, Equals, "ping,persistence")
+
+	o = GroupOptions{Backend: BackendCluster, Probes: "canary"}
+	c.Assert(o.validate(), HasLen, 1)
+}
+
+func (s *groupTestSuite) TestRoleCache(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	// redis before 2.8.12 has no ROLE
+	master := rs.add(nil)
+	slave := rs.add(master)
+	master.update(func(n *fakeRedis) {
+		n.hook = func(args []string) (interface{}, bool) {
+			if strings.ToUpper(args[0]) == "ROLE" {
+				return redis.Error("ERR unknown command 'ROLE'"), true
+			}
+			return nil, false
+		}
+	})
+
+	g := newFakeGroup(c, master, GroupOptions{})
+	c.Assert(g.Slaves[slave.Addr], NotNil)
+
+	for i := 0; i < 2; i++ {
+		c.Assert(g.Check(), IsNil)
+	}
+
+	// ROLE is sent only once for the node
+	c.Assert(master.commands("ROLE"), HasLen, 1)
+	c.Assert(master.commands("INFO REPLICATION"), HasLen, 3)
+	c.Assert(g.Master.noRole, Equals, true)
+
+	// try ROLE again after reconnecting, the server may be upgraded
+	g.Master.close()
+	c.Assert(g.Check(), IsNil)
+	c.Assert(master.commands("ROLE"), HasLen, 2)
+}
+
+func (s *groupTestSuite) TestRefreshRedisCluster(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	// the hung seed never replies
+	hung := make(chan struct{})
+	defer close(hung)
+
+	bad := rs.add(nil)
+	bad.hook = func(args []string) (interface{}, bool) {
+		<-hung
+		return nil, false
+	}
+
+	good := rs.add(nil)
+	good.hook = func(args []string) (interface{}, bool) {
+		if strings.ToUpper(strings.Join(args, " ")) == "CLUSTER NODES" {
+			return testClusterNodes, true
+		}
+		return nil, false
+	}
+
+	cfg := &Config{Addr: "127.0.0.1:11000", RedisCluster: RedisClusterConfig{Seeds: []string{bad.Addr, good.Addr}}}
+	a := newCheckApp(cfg)
+
+	// the group is created with the cluster backend when the master is added
+	backends := make(map[string]string)
+	a.masters.onChange = func(added []string, removed []string) {
+		for _, addr := range added {
+			backends[addr] = a.masters.GetOptions(addr).Backend
+		}
+	}
+
+	t := time.Now()
+	a.refreshRedisCluster(cfg, t)
+	// the check loop is not stalled by the hung seed
+	c.Assert(time.Since(t) < time.Second, Equals, true)
+
+	// and the last refresh is still running
+	a.refreshRedisCluster(cfg, t.Add(time.Second))
+	c.Assert(a.clusterRefreshed.Equal(t), Equals, true)
+
+	a.wg.Wait()
+	c.Assert(time.Since(t) < 2*clusterSeedTimeout, Equals, true)
+	masters := a.masters.GetMasters()
+	sort.Strings(masters)
+	c.Assert(masters, DeepEquals, []string{"127.0.0.1:30001", "127.0.0.1:30002", "127.0.0.1:30003"})
+	c.Assert(backends, DeepEquals, map[string]string{
+		"127.0.0.1:30001": BackendCluster,
+		"127.0.0.1:30002": BackendCluster,
+		"127.0.0.1:30003": BackendCluster,
+	})
+
+	// the monitored master keeps its options
+	a.masters.DelMasters([]string{"127.0.0.1:30001"})
+	a.masters.AddMasters([]string{"127.0.0.1:30001"})
+	a.masters.SetOptions([]string{"127.0.0.1:30001"}, &GroupOptions{MaxDownTime: 5})
+	a.masters.AddMastersWithOptions([]string{"127.0.0.1:30001"}, &GroupOptions{Backend: BackendCluster})
+	c.Assert(a.masters.GetOptions("127.0.0.1:30001"), Equals, GroupOptions{MaxDownTime: 5})
+}
+
+func (s *groupTestSuite) TestCatchUp(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave1 := rs.add(master)
+	slave2 := rs.add(master)
+
+	master.update(func(n *fakeRedis) { n.offset = 40 })
+	slave1.update(func(n *fakeRedis) { n.offset = 10; n.noSync = true })
+	slave2.update(func(n *fakeRedis) { n.offset = 30; n.noSync = true })
+
+	g := newFakeGroup(c, master, GroupOptions{})
+	master.close()
+
+	type result struct {
+		loss int64
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		loss, err := g.CatchUp(slave1.Addr, 5*time.Second, 0)
+		done <- result{loss, err}
+	}()
+
+	// the candidate replicates from the most up-to-date slave
+	for slave1.getMaster() != slave2.Addr {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the status isn't blocked while waiting
+	status := make(chan GroupStatus, 1)
+	go func() {
+		status <- g.Status()
+	}()
+
+	select {
+	case st := <-status:
+		c.Assert(st.Master.Addr, Equals, master.Addr)
+	case <-time.After(time.Second):
+		c.Fatal("the group is locked while catching up")
+	}
+
+	slave1.update(func(n *fakeRedis) { n.offset = 30 })
+
+	r := <-done
+	c.Assert(r.err, IsNil)
+	// still behind the master offset we saw before it is down
+	c.Assert(r.loss, Equals, int64(10))
+	c.Assert(slave1.commands("REPLICAOF"), HasLen, 1)
+
+	// give up the candidate losing too much data, it replicates from the old master again
+	slave2.update(func(n *fakeRedis) { n.offset = 35 })
+	loss, err := g.CatchUp(slave1.Addr, 300*time.Millisecond, 8)
+	c.Assert(loss, Equals, int64(10))
+	c.Assert(err, FitsTypeOf, &DataLossError{})
+	c.Assert(slave1.getMaster(), Equals, master.Addr)
+
+	// no slave is ahead, nothing to catch up
+	loss, err = g.CatchUp(slave2.Addr, time.Second, 0)
+	c.Assert(err, IsNil)
+	c.Assert(loss, Equals, int64(5))
+	c.Assert(slave2.commands("REPLICAOF"), HasLen, 0)
+}
+
+func (s *groupTestSuite) TestVerify(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave1 := rs.add(master)
+	slave2 := rs.add(master)
+	slave3 := rs.add(master)
+
+	g := newFakeGroup(c, master, GroupOptions{})
+	master.close()
+
+	c.Assert(g.Promote(slave1.Addr), IsNil)
+	c.Assert(slave1.getMaster(), Equals, "")
+	c.Assert(slave2.getMaster(), Equals, slave1.Addr)
+
+	// the slave goes back to the old master, e.g, restarted with the old config
+	slave3.update(func(n *fakeRedis) { n.master = master.Addr })
+
+	h := g.Verify(3 * time.Second)
+	c.Assert(h.Healthy, Equals, true)
+	c.Assert(h.Master, Equals, slave1.Addr)
+	c.Assert(h.ConnectedSlaves, Equals, 2)
+	c.Assert(h.Slaves, HasLen, 2)
+	for _, sh := range h.Slaves {
+		c.Assert(sh.LinkUp, Equals, true)
+		if sh.Addr == slave3.Addr {
+			c.Assert(sh.Resent, Equals, 1)
+		} else {
+			c.Assert(sh.Resent, Equals, 0)
+		}
+	}
+	c.Assert(slave3.getMaster(), Equals, slave1.Addr)
+
+	// the down slave makes the topology unhealthy after the timeout
+	slave2.close()
+
+	t := time.Now()
+	done := make(chan *TopologyHealth, 1)
+	go func() { done <- g.Verify(600 * time.Millisecond) }()
+
+	// the status is not blocked while waiting
+	time.Sleep(100 * time.Millisecond)
+	g.Status()
+	c.Assert(time.Since(t) < 500*time.Millisecond, Equals, true)
+
+	h = <-done
+	c.Assert(time.Since(t) >= 600*time.Millisecond, Equals, true)
+	c.Assert(h.Healthy, Equals, false)
+	c.Assert(h.ConnectedSlaves, Equals, 1)
+	for _, sh := range h.Slaves {
+		if sh.Addr == slave2.Addr {
+			c.Assert(sh.LinkUp, Equals, false)
+			c.Assert(sh.Error, Not(Equals), "")
+		}
+	}
+}
+
+func (s *groupTestSuite) TestReplicaOfCache(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave := rs.add(master)
+	slave.update(func(n *fakeRedis) { n.version = "4.0.14" })
+
+	g := newFakeGroup(c, master, GroupOptions{})
+	node := g.Slaves[slave.Addr]
+	host, port, _ := splitAddr(master.Addr)
+
+	for i := 0; i < 3; i++ {
+		c.Assert(g.slaveof(node, host, port), IsNil)
+	}
+
+	// the command is detected only once for the node
+	c.Assert(slave.commands("INFO SERVER"), HasLen, 1)
+	c.Assert(slave.commands("SLAVEOF"), HasLen, 3)
+	c.Assert(node.replCmd, Equals, "SLAVEOF")
+
+	// detect again after reconnecting, the server may be upgraded
+	node.close()
+	slave.update(func(n *fakeRedis) { n.version = "7.2.4" })
+	c.Assert(g.slaveof(node, host, port), IsNil)
+	c.Assert(slave.commands("INFO SERVER"), HasLen, 2)
+	c.Assert(slave.commands("REPLICAOF"), HasLen, 1)
+}
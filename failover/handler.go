@@ -1,8 +1,14 @@
 package failover
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type masterHandler struct {
@@ -12,19 +18,418 @@ type masterHandler struct {
 func (h *masterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
-		masters := h.a.masters.GetMasters()
+		consistency := r.FormValue("consistency")
+		if err := validateConsistency(consistency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		masters, err := h.a.GetMasters(consistency)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 		w.Write([]byte(strings.Join(masters, ",")))
 	case "POST":
 		masters := strings.Split(r.FormValue("masters"), ",")
-		h.a.addMasters(masters)
+		writeError(w, h.a.addMasters(masters))
 	case "PUT":
 		masters := strings.Split(r.FormValue("masters"), ",")
-		h.a.setMasters(masters)
+		writeError(w, h.a.setMasters(masters))
 	case "DELETE":
 		masters := strings.Split(r.FormValue("masters"), ",")
-		h.a.delMasters(masters)
+		writeError(w, h.a.delMasters(masters))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+
+	code := http.StatusInternalServerError
+	if err == ErrNotLeader {
+		code = http.StatusServiceUnavailable
+	} else if _, ok := err.(*DataLossError); ok {
+		code = http.StatusConflict
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+type statusHandler struct {
+	a *App
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, h.a.Status())
+}
+
+type peerHandler struct {
+	a *App
+}
+
+func (h *peerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rf, err := h.a.raft()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	peer := r.FormValue("peer")
+
+	switch r.Method {
+	case "GET":
+		peers, err := rf.GetPeers()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, peers)
+	case "POST":
+		writeError(w, rf.AddPeer(peer))
+	case "DELETE":
+		writeError(w, rf.DelPeer(peer))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+type snapshotHandler struct {
+	a *App
+}
+
+func (h *snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rf, err := h.a.raft()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeError(w, rf.Snapshot())
+}
+
+type backupHandler struct {
+	a *App
+}
+
+func (h *backupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	consistency := r.FormValue("consistency")
+	if err := validateConsistency(consistency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b, err := h.a.Backup(consistency)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, b)
+}
+
+type switchoverHandler struct {
+	a *App
+}
+
+func (h *switchoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	master := r.FormValue("master")
+	if len(master) == 0 {
+		http.Error(w, "empty master", http.StatusBadRequest)
+		return
+	}
+
+	newMaster, err := h.a.Switchover(master, r.FormValue("target"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Write([]byte(newMaster))
+}
+
+type historyHandler struct {
+	a *App
+}
+
+func (h *historyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, h.a.history.Records())
+}
+
+type eventHandler struct {
+	a *App
+}
+
+// Stream events using server-sent events, the client can resume from a cursor
+// with the Last-Event-ID header or the cursor query parameter.
+func (h *eventHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	cursor := r.Header.Get("Last-Event-ID")
+	if len(cursor) == 0 {
+		cursor = r.FormValue("cursor")
+	}
+
+	var id uint64
+	if len(cursor) > 0 {
+		var err error
+		if id, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid cursor %s", cursor), http.StatusBadRequest)
+			return
+		}
+	}
+
+	backlog, ch := h.a.events.Subscribe(id)
+	defer h.a.events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// too slow or app closed, client should reconnect with the last cursor
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-t.C:
+			// keep alive
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-h.a.quit:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+type reloadHandler struct {
+	a *App
+}
+
+func (h *reloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeError(w, h.a.Reload())
+}
+
+type groupOptionsInfo struct {
+	Master    string       `json:"master"`
+	Options   GroupOptions `json:"options"`
+	Effective GroupOptions `json:"effective"`
+}
+
+type groupHandler struct {
+	a *App
+}
+
+func (h *groupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	master := r.FormValue("master")
+
+	switch r.Method {
+	case "GET":
+		c := h.a.config()
+		masters := h.a.masters.GetMasters()
+		sort.Strings(masters)
+
+		infos := make([]groupOptionsInfo, 0, len(masters))
+		for _, m := range masters {
+			if len(master) > 0 && m != master {
+				continue
+			}
+
+			opts := h.a.masters.GetOptions(m)
+			infos = append(infos, groupOptionsInfo{Master: m, Options: opts, Effective: opts.effective(c, h.a.groupHooks(c, m))})
+		}
+		writeJSON(w, infos)
+	case "PUT", "DELETE":
+		if !h.a.masters.IsMaster(master) {
+			http.Error(w, fmt.Sprintf("%s is not monitored master", master), http.StatusBadRequest)
+			return
+		}
+
+		var opts *GroupOptions
+		if r.Method == "PUT" {
+			var err error
+			if err = r.ParseForm(); err == nil {
+				opts, err = parseGroupOptions(r.Form)
+			}
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeError(w, h.a.setGroupOptions([]string{master}, opts))
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 }
+
+type failoverHandler struct {
+	a *App
+}
+
+func (h *failoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	master := r.FormValue("master")
+	if len(master) == 0 {
+		http.Error(w, "empty master", http.StatusBadRequest)
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.FormValue("force"))
+
+	newMaster, err := h.a.Failover(master, r.FormValue("target"), force)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Write([]byte(newMaster))
+}
+
+type maintenanceHandler struct {
+	a *App
+}
+
+func (h *maintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	masters := strings.Split(r.FormValue("masters"), ",")
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, h.a.masters.GetAllMaintenance())
+	case "POST":
+		var d time.Duration
+		if v := r.FormValue("duration"); len(v) > 0 {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid duration %s", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, master := range masters {
+			if !h.a.masters.IsMaster(master) {
+				http.Error(w, fmt.Sprintf("%s is not monitored master", master), http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeError(w, h.a.EnterMaintenance(masters, d, r.FormValue("reason")))
+	case "DELETE":
+		writeError(w, h.a.LeaveMaintenance(masters))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+type discoveryHandler struct {
+	a *App
+}
+
+func (h *discoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, h.a.LastDiscovery())
+	case "POST":
+		force, _ := strconv.ParseBool(r.FormValue("force"))
+		dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+
+		// the blocked removal is shown in the result
+		res, err := h.a.Discover(force, dryRun)
+		if res == nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, res)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,114 @@
+package failover
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	FailoverReasonDown       = "down"
+	FailoverReasonSwitchover = "switchover"
+	FailoverReasonManual     = "manual"
+	FailoverReasonObserve    = "observe"
+	// the master can be reached but fails the probes
+	FailoverReasonUnhealthy = "unhealthy"
+)
+
+// how many failover records we keep in memory
+const historySize = 100
+
+// A failover record describes one failover or switchover done by this node.
+type FailoverRecord struct {
+	ID        uint64    `json:"id"`
+	Reason    string    `json:"reason"`
+	Master    string    `json:"master"`
+	NewMaster string    `json:"new_master,omitempty"`
+	Begin     time.Time `json:"begin"`
+	End       time.Time `json:"end"`
+	Error     string    `json:"error,omitempty"`
+	// The failover is not done really in observe only mode
+	DryRun bool   `json:"dry_run,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// The bytes the new master is behind the most up-to-date node we know
+	DataLoss int64 `json:"data_loss,omitempty"`
+	// The replication topology verified after failover
+	Topology *TopologyHealth `json:"topology,omitempty"`
+	// The fence kept on the down master which can't be demoted, must be released manually
+	Fence string `json:"fence,omitempty"`
+}
+
+type failoverHistory struct {
+	m sync.Mutex
+
+	lastID  uint64
+	records []*FailoverRecord
+}
+
+func newFailoverHistory() *failoverHistory {
+	h := new(failoverHistory)
+	h.records = make([]*FailoverRecord, 0, historySize)
+	return h
+}
+
+func (h *failoverHistory) Begin(master string, reason string) *FailoverRecord {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.lastID++
+	r := &FailoverRecord{
+		ID:     h.lastID,
+		Reason: reason,
+		Master: master,
+		Begin:  time.Now(),
+	}
+
+	if len(h.records) == historySize {
+		copy(h.records, h.records[1:])
+		h.records = h.records[:historySize-1]
+	}
+	h.records = append(h.records, r)
+	return r
+}
+
+// Add adds a finished record.
+func (h *failoverHistory) Add(r FailoverRecord) {
+	rr := h.Begin(r.Master, r.Reason)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	id := rr.ID
+	*rr = r
+	rr.ID = id
+}
+
+// Update changes the running record.
+func (h *failoverHistory) Update(r *FailoverRecord, f func(r *FailoverRecord)) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	f(r)
+}
+
+func (h *failoverHistory) End(r *FailoverRecord, newMaster string, err error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	r.NewMaster = newMaster
+	r.End = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Records returns the copied records, the latest is the last.
+func (h *failoverHistory) Records() []FailoverRecord {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	rs := make([]FailoverRecord, 0, len(h.records))
+	for _, r := range h.records {
+		rs = append(rs, *r)
+	}
+	return rs
+}
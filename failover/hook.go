@@ -0,0 +1,54 @@
+package failover
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+// runHook runs the shell command with args, the args can be used as $1, $2 in command.
+func runHook(name string, command string, timeout time.Duration, args ...string) error {
+	if len(command) == 0 {
+		return nil
+	}
+
+	cmdArgs := append([]string{"-c", command, name}, args...)
+	cmd := exec.Command("/bin/sh", cmdArgs...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("REDIS_FAILOVER_HOOK=%s", name))
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	// the processes started by the hook hold the output too, so on timeout
+	// we must kill all of them, not only the shell.
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		select {
+		case err = <-done:
+		case <-time.After(timeout):
+			killProcessGroup(cmd)
+			<-done
+			err = fmt.Errorf("timeout after %s", timeout)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("run %s hook %q %v err %v, output: %s", name, command, args, err, strings.TrimSpace(out.String()))
+	}
+
+	log.Infof("run %s hook %q %v ok", name, command, args)
+	return nil
+}
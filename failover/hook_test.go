@@ -0,0 +1,103 @@
+package failover
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type hookTestSuite struct {
+}
+
+var _ = Suite(&hookTestSuite{})
+
+func (s *hookTestSuite) TestRunHook(c *C) {
+	dir, err := ioutil.TempDir("", "hook")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "out")
+
+	// the empty hook does nothing
+	c.Assert(runHook("after_failover", "", time.Second), IsNil)
+
+	cmd := `echo "$REDIS_FAILOVER_HOOK $0 $1 $2" > ` + name
+	c.Assert(runHook("after_failover", cmd, time.Second, "127.0.0.1:6379", "127.0.0.1:6380"), IsNil)
+
+	data, err := ioutil.ReadFile(name)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "after_failover after_failover 127.0.0.1:6379 127.0.0.1:6380\n")
+
+	err = runHook("before_failover", "echo oops; exit 3", time.Second, "127.0.0.1:6379")
+	c.Assert(err, ErrorMatches, `run before_failover hook .* exit status 3, output: oops`)
+}
+
+func (s *hookTestSuite) TestHookTimeout(c *C) {
+	t := time.Now()
+	err := runHook("before_failover", "sleep 10", 100*time.Millisecond, "127.0.0.1:6379")
+	c.Assert(err, ErrorMatches, ".*timeout after 100ms.*")
+	c.Assert(time.Since(t) < 5*time.Second, Equals, true)
+}
+
+func (s *hookTestSuite) TestHooksOnlyFromConfig(c *C) {
+	cfg := &Config{
+		Addr:  "127.0.0.1:11000",
+		Hooks: HooksConfig{BeforeFailover: "global.sh"},
+		Groups: []GroupConfig{{
+			Master:       "127.0.0.1:6379",
+			Slaves:       []string{"127.0.0.1:6380"},
+			GroupOptions: GroupOptions{Hooks: HooksConfig{AfterFailover: "group.sh", Timeout: 3}},
+		}},
+	}
+
+	a := newDiscoveryApp(cfg)
+	a.masters.AddMasters(cfg.allMasters())
+	c.Assert(a.applyGroupConfigs(nil, cfg.Groups), IsNil)
+
+	// the hooks are never saved in cluster
+	c.Assert(a.masters.GetOptions("127.0.0.1:6379").Hooks, Equals, HooksConfig{})
+
+	o := a.masters.GetOptions("127.0.0.1:6379")
+	o = o.effective(cfg, a.groupHooks(cfg, "127.0.0.1:6379"))
+	c.Assert(o.Hooks, Equals, HooksConfig{BeforeFailover: "global.sh", AfterFailover: "group.sh", Timeout: 3})
+
+	// the promoted slave still uses the group hooks
+	c.Assert(a.groupHooks(cfg, "127.0.0.1:6380"), Equals, HooksConfig{AfterFailover: "group.sh", Timeout: 3})
+	c.Assert(a.groupHooks(cfg, "127.0.0.1:6381"), Equals, HooksConfig{})
+
+	// and the saved hooks are ignored
+	o = GroupOptions{Hooks: HooksConfig{BeforeFailover: "rm -rf /"}}.effective(cfg, HooksConfig{})
+	c.Assert(o.Hooks.BeforeFailover, Equals, "global.sh")
+
+	// the HTTP API can't set the hooks
+	_, err := parseGroupOptions(url.Values{"after_failover": {"touch /tmp/x"}})
+	c.Assert(err, ErrorMatches, "hooks can only be set in config file")
+
+	h := &groupHandler{a}
+	for _, name := range []string{"before_failover", "after_failover"} {
+		form := url.Values{"master": {"127.0.0.1:6379"}, name: {"touch /tmp/x"}}
+		r := httptest.NewRequest("PUT", "/groups", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		c.Assert(w.Code, Equals, http.StatusBadRequest)
+	}
+
+	// nor the raft log, the zk data or the snapshot
+	bad := GroupOptions{MaxDownTime: 3, Hooks: HooksConfig{BeforeFailover: "touch /tmp/x"}}
+	a.masters.SetOptions([]string{"127.0.0.1:6379"}, &bad)
+	c.Assert(a.masters.GetOptions("127.0.0.1:6379"), Equals, GroupOptions{MaxDownTime: 3})
+
+	a.masters.ReplaceOptions(map[string]GroupOptions{"127.0.0.1:6379": bad})
+	c.Assert(a.masters.GetOptions("127.0.0.1:6379"), Equals, GroupOptions{MaxDownTime: 3})
+
+	a.masters.restore(&masterSnapshot{Masters: []string{"127.0.0.1:6379"}, Options: map[string]GroupOptions{"127.0.0.1:6379": bad}})
+	c.Assert(a.masters.GetOptions("127.0.0.1:6379"), Equals, GroupOptions{MaxDownTime: 3})
+}
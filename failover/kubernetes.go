@@ -0,0 +1,474 @@
+package failover
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+// the pods found by the kubernetes labels
+const SourceKubernetes = "kubernetes"
+
+const (
+	defaultKubeGroupLabel = "redis-failover/group"
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var errKubeNotFound = errors.New("kubernetes object not found")
+
+// KubePod is the part of a pod we care about.
+type KubePod struct {
+	Name   string
+	IP     string
+	Labels map[string]string
+	// <hostname>.<subdomain>.<namespace>.svc if the pod is behind a headless service
+	DNSName string
+}
+
+// KubeClient is the subset of the kubernetes API we use,
+// the tests use a fake API server instead of a real cluster.
+type KubeClient interface {
+	ListPods(namespace string, selector string) ([]KubePod, error)
+	// GetEndpoints returns the IPs of the Endpoints, errKubeNotFound if not exists
+	GetEndpoints(namespace string, name string) ([]string, error)
+	// SetEndpoints points the Endpoints at ip:port only, it is created if not exists
+	SetEndpoints(namespace string, name string, ip string, port int) error
+}
+
+// kubeHTTPClient talks to the API server with the REST API directly.
+type kubeHTTPClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newKubeClient uses the service account of the pod if the API server is not set.
+func newKubeClient(c KubernetesConfig) (*kubeHTTPClient, error) {
+	server := c.APIServer
+	if len(server) == 0 {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if len(host) == 0 {
+			return nil, fmt.Errorf("kubernetes.api_server is not set and not running in a pod")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	k := &kubeHTTPClient{server: strings.TrimSuffix(server, "/")}
+
+	tokenFile, caFile := c.TokenFile, c.CAFile
+	if len(c.APIServer) == 0 {
+		if len(tokenFile) == 0 {
+			tokenFile = kubeServiceAccountDir + "/token"
+		}
+		if len(caFile) == 0 {
+			caFile = kubeServiceAccountDir + "/ca.crt"
+		}
+	}
+
+	if len(tokenFile) > 0 {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		k.token = strings.TrimSpace(string(token))
+	}
+
+	tr := &http.Transport{}
+	if len(caFile) > 0 {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	k.client = &http.Client{Transport: tr, Timeout: 10 * time.Second}
+	return k, nil
+}
+
+func (k *kubeHTTPClient) do(method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, k.server+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(k.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errKubeNotFound
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	case out != nil:
+		return json.Unmarshal(data, out)
+	default:
+		return nil
+	}
+}
+
+type kubeMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+}
+
+type kubePodList struct {
+	Items []struct {
+		Metadata kubeMeta `json:"metadata"`
+		Spec     struct {
+			Hostname  string `json:"hostname"`
+			Subdomain string `json:"subdomain"`
+		} `json:"spec"`
+		Status struct {
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubeEndpointAddress struct {
+	IP string `json:"ip"`
+}
+
+type kubeEndpointPort struct {
+	Name string `json:"name,omitempty"`
+	Port int    `json:"port"`
+}
+
+type kubeEndpointSubset struct {
+	Addresses []kubeEndpointAddress `json:"addresses"`
+	Ports     []kubeEndpointPort    `json:"ports"`
+}
+
+type kubeEndpoints struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   kubeMeta             `json:"metadata"`
+	Subsets    []kubeEndpointSubset `json:"subsets"`
+}
+
+// ListPods returns the running pods matching the label selector, the deleting pods are skipped.
+func (k *kubeHTTPClient) ListPods(namespace string, selector string) ([]KubePod, error) {
+	var list kubePodList
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s", namespace, url.QueryEscape(selector))
+	if err := k.do("GET", path, nil, &list); err != nil {
+		return nil, err
+	}
+
+	pods := make([]KubePod, 0, len(list.Items))
+	for _, item := range list.Items {
+		if len(item.Status.PodIP) == 0 || len(item.Metadata.DeletionTimestamp) > 0 {
+			continue
+		}
+
+		p := KubePod{Name: item.Metadata.Name, IP: item.Status.PodIP, Labels: item.Metadata.Labels}
+		if len(item.Spec.Hostname) > 0 && len(item.Spec.Subdomain) > 0 {
+			p.DNSName = fmt.Sprintf("%s.%s.%s.svc", item.Spec.Hostname, item.Spec.Subdomain, namespace)
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
+func (k *kubeHTTPClient) getEndpoints(namespace string, name string) (*kubeEndpoints, error) {
+	ep := new(kubeEndpoints)
+	if err := k.do("GET", fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", namespace, name), nil, ep); err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+func (k *kubeHTTPClient) GetEndpoints(namespace string, name string) ([]string, error) {
+	ep, err := k.getEndpoints(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, s := range ep.Subsets {
+		for _, addr := range s.Addresses {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips, nil
+}
+
+// SetEndpoints replaces the subsets of the Endpoints, the Service must have no selector,
+// otherwise the endpoints controller overwrites it.
+func (k *kubeHTTPClient) SetEndpoints(namespace string, name string, ip string, port int) error {
+	ep, err := k.getEndpoints(namespace, name)
+	create := err == errKubeNotFound
+	if err != nil && !create {
+		return err
+	}
+
+	if create {
+		ep = &kubeEndpoints{Metadata: kubeMeta{Name: name, Namespace: namespace}}
+	}
+
+	ep.APIVersion = "v1"
+	ep.Kind = "Endpoints"
+	ep.Subsets = []kubeEndpointSubset{{
+		Addresses: []kubeEndpointAddress{{IP: ip}},
+		Ports:     []kubeEndpointPort{{Name: "redis", Port: port}},
+	}}
+
+	if create {
+		return k.do("POST", fmt.Sprintf("/api/v1/namespaces/%s/endpoints", namespace), ep, nil)
+	}
+	// the resourceVersion makes the update fail if someone else changed it
+	return k.do("PUT", fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", namespace, name), ep, nil)
+}
+
+// kubeSource finds the groups from the pods, the pods with the same group label are a group.
+type kubeSource struct {
+	c      KubernetesConfig
+	client KubeClient
+}
+
+func (s *kubeSource) Name() string {
+	return fmt.Sprintf("%s:%s/%s", SourceKubernetes, s.c.Namespace, s.c.Selector)
+}
+
+// Groups uses the first pod by name as the master, like pod-0 of a StatefulSet,
+// the others are the slaves. If it is a slave now after failover, the discovery
+// keeps the monitored master because we know its slaves.
+func (s *kubeSource) Groups() ([]GroupConfig, error) {
+	groups, err := s.groupPods()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gs := make([]GroupConfig, 0, len(names))
+	for _, name := range names {
+		pods := groups[name]
+		g := GroupConfig{Master: s.podAddr(pods[0])}
+		for _, p := range pods[1:] {
+			g.Slaves = append(g.Slaves, s.podAddr(p))
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// groupPods returns group -> pods sorted by name, the pods without the group label are skipped.
+func (s *kubeSource) groupPods() (map[string][]KubePod, error) {
+	pods, err := s.client.ListPods(s.c.Namespace, s.c.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	groups := make(map[string][]KubePod)
+	for _, p := range pods {
+		if name := p.Labels[s.c.GroupLabel]; len(name) > 0 {
+			groups[name] = append(groups[name], p)
+		}
+	}
+	return groups, nil
+}
+
+// podAddr prefers the stable DNS name, the pod IP changes after rescheduling.
+func (s *kubeSource) podAddr(p KubePod) string {
+	host := p.IP
+	if len(p.DNSName) > 0 {
+		host = p.DNSName
+	}
+	return net.JoinHostPort(host, fmt.Sprint(s.c.Port))
+}
+
+// podIs returns true if addr is the pod, addr may use the DNS name or IP.
+func (s *kubeSource) podIs(p KubePod, addr string) bool {
+	return addr == s.podAddr(p) || addr == net.JoinHostPort(p.IP, fmt.Sprint(s.c.Port))
+}
+
+// kubeSource returns the kubernetes source, or nil if not configured.
+func (a *App) kubeSource(c *Config) (*kubeSource, error) {
+	if len(c.Kubernetes.Selector) == 0 {
+		return nil, nil
+	}
+
+	client, err := a.getKubeClient(c.Kubernetes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubeSource{c: c.Kubernetes, client: client}, nil
+}
+
+// getKubeClient returns the client built for the config, it is rebuilt only if the config
+// is changed, so the connections to the API server are reused.
+func (a *App) getKubeClient(c KubernetesConfig) (KubeClient, error) {
+	if a.kubeClient != nil {
+		return a.kubeClient, nil
+	}
+
+	a.kMutex.Lock()
+	defer a.kMutex.Unlock()
+
+	if a.kubeHTTP != nil && a.kubeHTTPConfig == c {
+		return a.kubeHTTP, nil
+	}
+
+	k, err := newKubeClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.kubeHTTP != nil {
+		a.kubeHTTP.client.CloseIdleConnections()
+	}
+
+	a.kubeHTTP, a.kubeHTTPConfig = k, c
+	return k, nil
+}
+
+// syncKubeEndpoints points the Endpoints of every group at its monitored master.
+func (a *App) syncKubeEndpoints() error {
+	c := a.config()
+	if len(c.Kubernetes.EndpointsPrefix) == 0 {
+		return nil
+	}
+
+	s, err := a.kubeSource(c)
+	if s == nil || err != nil {
+		return err
+	}
+
+	groups, err := s.groupPods()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for name, pods := range groups {
+		var masters []KubePod
+		for _, p := range pods {
+			if a.masters.IsMaster(s.podAddr(p)) || a.masters.IsMaster(net.JoinHostPort(p.IP, fmt.Sprint(s.c.Port))) {
+				masters = append(masters, p)
+			}
+		}
+
+		// the old master is still monitored for a while after failover,
+		// the after failover handler has updated the Endpoints then
+		if len(masters) != 1 {
+			continue
+		}
+
+		if err := a.setKubeEndpoints(s, name, masters[0]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// onKubeFailover points the Endpoints of the group at the new master.
+func (a *App) onKubeFailover(downMaster string, newMaster string) error {
+	c := a.config()
+	if len(c.Kubernetes.EndpointsPrefix) == 0 {
+		return nil
+	}
+
+	s, err := a.kubeSource(c)
+	if s == nil || err != nil {
+		return err
+	}
+
+	groups, err := s.groupPods()
+	if err != nil {
+		return err
+	}
+
+	for name, pods := range groups {
+		for _, p := range pods {
+			if s.podIs(p, newMaster) {
+				return a.setKubeEndpoints(s, name, p)
+			}
+		}
+	}
+
+	return fmt.Errorf("new master %s is not a pod of %s", newMaster, s.Name())
+}
+
+// setKubeEndpoints updates the Endpoints only if it doesn't point at the master.
+func (a *App) setKubeEndpoints(s *kubeSource, group string, master KubePod) error {
+	name := s.c.EndpointsPrefix + group
+
+	ips, err := s.client.GetEndpoints(s.c.Namespace, name)
+	if err != nil && err != errKubeNotFound {
+		return fmt.Errorf("get endpoints %s err %v", name, err)
+	}
+
+	if len(ips) == 1 && ips[0] == master.IP {
+		return nil
+	}
+
+	if err := s.client.SetEndpoints(s.c.Namespace, name, master.IP, s.c.Port); err != nil {
+		return fmt.Errorf("set endpoints %s err %v", name, err)
+	}
+
+	log.Infof("endpoints %s/%s points at %s(%s) now, was %v", s.c.Namespace, name, master.Name, master.IP, ips)
+
+	a.events.Publish(&Event{
+		Type: EventEndpointsUpdated,
+		Node: s.podAddr(master),
+		Data: map[string]interface{}{"endpoints": name, "ip": master.IP, "old": ips},
+	})
+	return nil
+}
@@ -0,0 +1,204 @@
+package failover
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type kubernetesTestSuite struct {
+}
+
+var _ = Suite(&kubernetesTestSuite{})
+
+// fakeKubeServer is a tiny API server with the pods and Endpoints in one namespace.
+type fakeKubeServer struct {
+	m         sync.Mutex
+	pods      []map[string]interface{}
+	endpoints map[string]*kubeEndpoints
+	selectors []string
+	token     string
+}
+
+func (f *fakeKubeServer) addPod(name string, ip string, group string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.pods = append(f.pods, map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "labels": map[string]string{"app": "redis", defaultKubeGroupLabel: group}},
+		"spec":     map[string]interface{}{"hostname": name, "subdomain": "redis"},
+		"status":   map[string]interface{}{"podIP": ip},
+	})
+}
+
+func (f *fakeKubeServer) endpointsIPs(name string) []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	ep, ok := f.endpoints[name]
+	if !ok {
+		return nil
+	}
+
+	var ips []string
+	for _, s := range ep.Subsets {
+		for _, addr := range s.Addresses {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips
+}
+
+func (f *fakeKubeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/api/v1/namespaces/redis/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case path == "pods" && r.Method == "GET":
+		f.selectors = append(f.selectors, r.URL.Query().Get("labelSelector"))
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": f.pods})
+	case path == "endpoints" && r.Method == "POST":
+		ep := new(kubeEndpoints)
+		json.NewDecoder(r.Body).Decode(ep)
+		f.endpoints[ep.Metadata.Name] = ep
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "endpoints/"):
+		name := strings.TrimPrefix(path, "endpoints/")
+		ep, ok := f.endpoints[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == "PUT" {
+			ep = new(kubeEndpoints)
+			json.NewDecoder(r.Body).Decode(ep)
+			f.endpoints[name] = ep
+		}
+		json.NewEncoder(w).Encode(ep)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeKubeServer() (*fakeKubeServer, *httptest.Server) {
+	f := &fakeKubeServer{endpoints: make(map[string]*kubeEndpoints), token: "secret"}
+	return f, httptest.NewServer(f)
+}
+
+func (s *kubernetesTestSuite) TestClient(c *C) {
+	f, ts := newFakeKubeServer()
+	defer ts.Close()
+
+	f.addPod("redis-a-1", "10.0.0.2", "a")
+	f.addPod("redis-a-0", "10.0.0.1", "a")
+	f.addPod("redis-b-0", "10.0.0.3", "b")
+	f.addPod("other", "10.0.0.4", "")
+
+	// no token
+	k, err := newKubeClient(KubernetesConfig{APIServer: ts.URL})
+	c.Assert(err, IsNil)
+	_, err = k.ListPods("redis", "app=redis")
+	c.Assert(err, NotNil)
+
+	k.token = f.token
+	pods, err := k.ListPods("redis", "app=redis")
+	c.Assert(err, IsNil)
+	c.Assert(pods, HasLen, 4)
+	c.Assert(pods[0].DNSName, Equals, "redis-a-1.redis.redis.svc")
+	c.Assert(f.selectors[len(f.selectors)-1], Equals, "app=redis")
+
+	_, err = k.GetEndpoints("redis", "redis-a")
+	c.Assert(err, Equals, errKubeNotFound)
+
+	c.Assert(k.SetEndpoints("redis", "redis-a", "10.0.0.1", 6379), IsNil)
+	c.Assert(k.SetEndpoints("redis", "redis-a", "10.0.0.2", 6379), IsNil)
+	ips, err := k.GetEndpoints("redis", "redis-a")
+	c.Assert(err, IsNil)
+	c.Assert(ips, DeepEquals, []string{"10.0.0.2"})
+
+	src := &kubeSource{c: KubernetesConfig{Namespace: "redis", Selector: "app=redis", GroupLabel: defaultKubeGroupLabel, Port: 6379}, client: k}
+	groups, err := src.Groups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 2)
+	c.Assert(groups[0].Master, Equals, "redis-a-0.redis.redis.svc:6379")
+	c.Assert(groups[0].Slaves, DeepEquals, []string{"redis-a-1.redis.redis.svc:6379"})
+	c.Assert(groups[1].Master, Equals, "redis-b-0.redis.redis.svc:6379")
+	c.Assert(groups[1].Slaves, HasLen, 0)
+}
+
+func (s *kubernetesTestSuite) TestClientReused(c *C) {
+	cfg := &Config{Kubernetes: KubernetesConfig{APIServer: "https://127.0.0.1:6443", Selector: "app=redis"}}
+	a := newDiscoveryApp(cfg)
+
+	s1, err := a.kubeSource(cfg)
+	c.Assert(err, IsNil)
+	s2, err := a.kubeSource(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(s2.client, Equals, s1.client)
+
+	// rebuilt after the config is reloaded
+	cfg2 := *cfg
+	cfg2.Kubernetes.APIServer = "https://127.0.0.1:6444"
+	s3, err := a.kubeSource(&cfg2)
+	c.Assert(err, IsNil)
+	c.Assert(s3.client, Not(Equals), s1.client)
+	c.Assert(s3.client.(*kubeHTTPClient).server, Equals, "https://127.0.0.1:6444")
+}
+
+func (s *kubernetesTestSuite) TestEndpoints(c *C) {
+	f, ts := newFakeKubeServer()
+	defer ts.Close()
+
+	f.addPod("redis-a-0", "10.0.0.1", "a")
+	f.addPod("redis-a-1", "10.0.0.2", "a")
+
+	a := newDiscoveryApp(&Config{
+		Discovery:  DiscoveryConfig{Sources: []SourceConfig{{Type: SourceKubernetes}}},
+		Kubernetes: KubernetesConfig{Namespace: "redis", Selector: "app=redis", EndpointsPrefix: "redis-"},
+	})
+	k, err := newKubeClient(KubernetesConfig{APIServer: ts.URL})
+	c.Assert(err, IsNil)
+	k.token = f.token
+	a.kubeClient = k
+
+	// the Endpoints is created for the discovered master
+	r, err := a.Discover(false, false)
+	c.Assert(err, IsNil)
+	c.Assert(r.Added, DeepEquals, []string{"redis-a-0.redis.redis.svc:6379"})
+	c.Assert(a.masters.GetSlaves("redis-a-0.redis.redis.svc:6379"), DeepEquals, []string{"redis-a-1.redis.redis.svc:6379"})
+	c.Assert(f.endpointsIPs("redis-a"), DeepEquals, []string{"10.0.0.1"})
+
+	// the master reports the new master with its IP
+	a.masters.AddMasters([]string{"10.0.0.2:6379"})
+	c.Assert(a.onKubeFailover("redis-a-0.redis.redis.svc:6379", "10.0.0.2:6379"), IsNil)
+	c.Assert(f.endpointsIPs("redis-a"), DeepEquals, []string{"10.0.0.2"})
+
+	// both are monitored, the sync keeps the Endpoints
+	c.Assert(a.syncKubeEndpoints(), IsNil)
+	c.Assert(f.endpointsIPs("redis-a"), DeepEquals, []string{"10.0.0.2"})
+
+	c.Assert(a.onKubeFailover("10.0.0.2:6379", "10.0.0.9:6379"), NotNil)
+
+	// the Endpoints changed by others is fixed in the next sync
+	a.masters.DelMasters([]string{"redis-a-0.redis.redis.svc:6379"})
+	c.Assert(k.SetEndpoints("redis", "redis-a", "10.0.0.1", 6379), IsNil)
+	c.Assert(a.syncKubeEndpoints(), IsNil)
+	c.Assert(f.endpointsIPs("redis-a"), DeepEquals, []string{"10.0.0.2"})
+}
@@ -0,0 +1,87 @@
+package failover
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ledisBackend monitors LedisDB, which replicates with the binlog.
+// The offsets are the binlog ids, not bytes, and a slave does FULLSYNC by itself
+// if the master no longer has the binlogs it needs after SLAVEOF.
+type ledisBackend struct{}
+
+// Role parses the LedisDB ROLE reply,
+// master: [master, last_log_id, [[host, port, last_log_id], ...]]
+// slave: [slave, master_host, master_port, state, last_log_id]
+func (ledisBackend) Role(do doFunc) (*RoleInfo, error) {
+	v, err := redis.Values(do("ROLE"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRole(v)
+}
+
+func (ledisBackend) ReplInfo(do doFunc) (*ReplInfo, error) {
+	v, err := redis.Values(do("ROLE"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLedisSlaveRole(v)
+}
+
+func parseLedisSlaveRole(v []interface{}) (*ReplInfo, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("invalid ROLE reply %v", v)
+	}
+
+	r := new(ReplInfo)
+	r.Role, _ = redis.String(v[0], nil)
+	// ledis doesn't report the last interaction with master
+	r.LastIOSeconds = -1
+
+	if r.Role != SlaveType {
+		return r, nil
+	}
+
+	if len(v) < 5 {
+		return nil, fmt.Errorf("invalid slave ROLE reply %v", v)
+	}
+
+	r.MasterHost = replyString(v[1])
+	r.MasterPort = replyString(v[2])
+
+	state, _ := redis.String(v[3], nil)
+	r.LinkUp = state == ConnectedState
+	r.Syncing = state == SyncState
+
+	r.Offset, _ = strconv.ParseInt(replyString(v[4]), 10, 64)
+
+	// ledis has no slave priority, all slaves are elected by the last log id
+	return r, nil
+}
+
+// SlaveOf uses SLAVEOF, the slave syncs from the last log id it has,
+// or does FULLSYNC if the new master can't provide it.
+func (ledisBackend) SlaveOf(do doFunc, host string, port string) error {
+	_, err := do("SLAVEOF", host, port)
+	return err
+}
+
+// ledisProbes returns the probes LedisDB supports, only ping now,
+// ledis has no SET PX and INFO persistence.
+func ledisProbes(probes string) string {
+	ps, _ := parseProbes(probes)
+
+	var s []string
+	for _, p := range ps {
+		if p == ProbePing {
+			s = append(s, p)
+		}
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,218 @@
+package failover
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+const (
+	SkipReasonCooldown    = "cooldown"
+	SkipReasonLimit       = "limit"
+	SkipReasonCircuitOpen = "circuit_open"
+)
+
+// failoverLimiter protects us from doing failover again and again when the network is flaky.
+type failoverLimiter struct {
+	m sync.Mutex
+
+	// master -> we can't do failover for it before the time
+	cooldown map[string]time.Time
+
+	// true if too many groups are down at the same time
+	open bool
+}
+
+func newFailoverLimiter() *failoverLimiter {
+	l := new(failoverLimiter)
+	l.cooldown = make(map[string]time.Time)
+	return l
+}
+
+// SetCooldown forbids the failover for the masters until the time.
+func (l *failoverLimiter) SetCooldown(addrs []string, until time.Time) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := time.Now()
+	for addr, t := range l.cooldown {
+		if !now.Before(t) {
+			delete(l.cooldown, addr)
+		}
+	}
+
+	for _, addr := range addrs {
+		if until.After(l.cooldown[addr]) {
+			l.cooldown[addr] = until
+		}
+	}
+}
+
+// Cooldown returns the time until which the master can't do failover.
+func (l *failoverLimiter) Cooldown(addr string, now time.Time) (time.Time, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	t, ok := l.cooldown[addr]
+	if !ok || !now.Before(t) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Trip opens or closes the circuit breaker, returns true if the state is changed.
+func (l *failoverLimiter) Trip(open bool) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.open == open {
+		return false
+	}
+
+	l.open = open
+	return true
+}
+
+func (l *failoverLimiter) IsOpen() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.open
+}
+
+// downGroups returns the number of groups which are down and would fail over automatically,
+// the groups in maintenance, observing only, disabling auto failover, or down shorter
+// than max_down_time are not counted.
+func (a *App) downGroups() int {
+	a.gMutex.Lock()
+	defer a.gMutex.Unlock()
+
+	now := time.Now()
+	n := 0
+	for master, g := range a.groups {
+		if g.CheckErrNum.Get() == 0 {
+			continue
+		}
+
+		_, opts := g.Options()
+		if opts.IsObserveOnly() || !opts.IsAutoFailover() {
+			continue
+		}
+
+		if g.downTime() < time.Duration(opts.MaxDownTime)*time.Second {
+			continue
+		}
+
+		if m := a.masters.GetMaintenance(master); m != nil && !m.Expired(now) {
+			continue
+		}
+
+		n++
+	}
+	return n
+}
+
+// failoverGroups does failover for the down groups found in one check,
+// the groups must be acquired and will be released here.
+func (a *App) failoverGroups(c *Config, groups []*Group) {
+	down := a.downGroups()
+
+	// too many groups are down at the same time, it is more likely that
+	// we are partitioned from them, so pause the failover.
+	open := c.MaxDownGroups > 0 && down > c.MaxDownGroups
+	if a.limiter.Trip(open) {
+		if open {
+			log.Errorf("%d groups are down, more than max_down_groups %d, pause failover", down, c.MaxDownGroups)
+			a.events.Publish(&Event{
+				Type: EventCircuitOpen,
+				Data: map[string]interface{}{"down_groups": down, "max_down_groups": c.MaxDownGroups},
+			})
+		} else {
+			log.Infof("%d groups are down, resume failover", down)
+			a.events.Publish(&Event{
+				Type: EventCircuitClosed,
+				Data: map[string]interface{}{"down_groups": down, "max_down_groups": c.MaxDownGroups},
+			})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Master.Addr < groups[j].Master.Addr })
+
+	var wg sync.WaitGroup
+	for i, g := range groups {
+		if open {
+			a.skipFailover(g, SkipReasonCircuitOpen)
+			g.Release()
+			continue
+		}
+
+		if c.MaxConcurrentFailover > 0 && i >= c.MaxConcurrentFailover {
+			// try again in next check
+			a.skipFailover(g, SkipReasonLimit)
+			g.Release()
+			continue
+		}
+
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			defer g.Release()
+
+			oldMaster := g.Master.Addr
+
+			log.Errorf("master %s is down, do failover", oldMaster)
+
+			reason := FailoverReasonDown
+			if masterReachable(g.CheckError()) {
+				reason = FailoverReasonUnhealthy
+			}
+
+			_, err := a.doFailover(g, reason, "", false)
+			if e, ok := err.(*DataLossError); ok {
+				// keep checking the master and wait approving
+				g.pending.Set(1)
+				a.events.Publish(&Event{
+					Type:   EventFailoverPending,
+					Master: oldMaster,
+					Data:   map[string]interface{}{"candidate": e.Candidate, "data_loss": e.Loss},
+				})
+			} else if err != nil && reason == FailoverReasonUnhealthy && a.masters.IsMaster(oldMaster) {
+				// the reachable master can't be fenced, don't try again and again
+				g.pending.Set(1)
+				a.events.Publish(&Event{
+					Type:   EventFailoverPending,
+					Master: oldMaster,
+					Data:   map[string]interface{}{"error": err.Error()},
+				})
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
+
+// skipFailover reports the failover is skipped, only once for the same reason until the master is alive.
+func (a *App) skipFailover(g *Group, reason string) {
+	if g.skipped.Get() == reason {
+		return
+	}
+	g.skipped.Set(reason)
+
+	master := g.Master.Addr
+	data := map[string]interface{}{"reason": reason}
+
+	switch reason {
+	case SkipReasonCooldown:
+		until, _ := a.limiter.Cooldown(master, time.Now())
+		data["until"] = until
+		log.Errorf("master %s is down, but in failover cooldown until %s, skip failover", master, until.Format(time.RFC3339))
+	case SkipReasonLimit:
+		log.Errorf("master %s is down, but too many failovers now, try later", master)
+	case SkipReasonCircuitOpen:
+		log.Errorf("master %s is down, but failover is paused", master)
+	}
+
+	a.events.Publish(&Event{Type: EventFailoverSkipped, Master: master, Data: data})
+}
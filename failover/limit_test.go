@@ -0,0 +1,82 @@
+package failover
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type limitTestSuite struct {
+}
+
+var _ = Suite(&limitTestSuite{})
+
+func (s *limitTestSuite) TestCooldown(c *C) {
+	l := newFailoverLimiter()
+
+	now := time.Now()
+	l.SetCooldown([]string{"127.0.0.1:6379", "127.0.0.1:6380"}, now.Add(time.Minute))
+
+	until, ok := l.Cooldown("127.0.0.1:6379", now)
+	c.Assert(ok, Equals, true)
+	c.Assert(until.Equal(now.Add(time.Minute)), Equals, true)
+
+	_, ok = l.Cooldown("127.0.0.1:6381", now)
+	c.Assert(ok, Equals, false)
+
+	_, ok = l.Cooldown("127.0.0.1:6380", now.Add(time.Minute))
+	c.Assert(ok, Equals, false)
+
+	// a shorter cooldown can't override the longer one
+	l.SetCooldown([]string{"127.0.0.1:6379"}, now.Add(time.Second))
+	until, _ = l.Cooldown("127.0.0.1:6379", now)
+	c.Assert(until.Equal(now.Add(time.Minute)), Equals, true)
+}
+
+func (s *limitTestSuite) TestTrip(c *C) {
+	l := newFailoverLimiter()
+
+	c.Assert(l.Trip(false), Equals, false)
+	c.Assert(l.Trip(true), Equals, true)
+	c.Assert(l.Trip(true), Equals, false)
+	c.Assert(l.IsOpen(), Equals, true)
+	c.Assert(l.Trip(false), Equals, true)
+	c.Assert(l.IsOpen(), Equals, false)
+}
+
+func (s *limitTestSuite) TestDownGroups(c *C) {
+	a := newCheckApp(&Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 2})
+
+	down := func(master string, errNum int32, opts GroupOptions) {
+		g := addTestGroup(a, master, opts)
+		g.CheckErrNum.Set(errNum)
+	}
+
+	observe := true
+	manual := false
+	down("127.0.0.1:6379", 2, GroupOptions{})
+	down("127.0.0.1:6380", 0, GroupOptions{})
+	// down shorter than max_down_time
+	down("127.0.0.1:6381", 1, GroupOptions{})
+	down("127.0.0.1:6382", 1, GroupOptions{MaxDownTime: 1})
+	down("127.0.0.1:6383", 5, GroupOptions{ObserveOnly: &observe})
+	down("127.0.0.1:6384", 5, GroupOptions{AutoFailover: &manual})
+	down("127.0.0.1:6385", 5, GroupOptions{})
+	down("127.0.0.1:6386", 5, GroupOptions{})
+	c.Assert(a.downGroups(), Equals, 4)
+
+	c.Assert(a.EnterMaintenance([]string{"127.0.0.1:6385"}, 0, ""), IsNil)
+	c.Assert(a.downGroups(), Equals, 3)
+
+	// the expired maintenance doesn't stop the failover
+	a.masters.SetMaintenance([]string{"127.0.0.1:6386"}, &Maintenance{Since: time.Now().Add(-time.Hour), Until: time.Now().Add(-time.Minute)})
+	c.Assert(a.downGroups(), Equals, 3)
+
+	// the circuit is not opened by the groups which never fail over
+	a.failoverGroups(&Config{MaxDownGroups: 3}, nil)
+	c.Assert(a.limiter.IsOpen(), Equals, false)
+
+	a.masters.SetMaintenance([]string{"127.0.0.1:6385"}, nil)
+	a.failoverGroups(&Config{MaxDownGroups: 3}, nil)
+	c.Assert(a.limiter.IsOpen(), Equals, true)
+}
@@ -0,0 +1,96 @@
+package failover
+
+import (
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+// Maintenance suspends the failover of the group, we still check the master and report it.
+// It is saved with the masters in the cluster.
+type Maintenance struct {
+	Since time.Time `json:"since"`
+	// zero means no expiry, must leave maintenance manually
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (m *Maintenance) Expired(now time.Time) bool {
+	return !m.Until.IsZero() && !now.Before(m.Until)
+}
+
+func (m *Maintenance) equal(o *Maintenance) bool {
+	return m.Since.Equal(o.Since) && m.Until.Equal(o.Until) && m.Reason == o.Reason
+}
+
+// EnterMaintenance suspends failover for the masters, if d > 0, the maintenance expires after d.
+func (a *App) EnterMaintenance(addrs []string, d time.Duration, reason string) error {
+	m := &Maintenance{
+		Since:  time.Now(),
+		Reason: reason,
+	}
+
+	if d > 0 {
+		m.Until = m.Since.Add(d)
+	}
+
+	return a.setMaintenance(addrs, m)
+}
+
+func (a *App) LeaveMaintenance(addrs []string) error {
+	return a.setMaintenance(addrs, nil)
+}
+
+func (a *App) setMaintenance(addrs []string, m *Maintenance) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	if a.cluster != nil {
+		if a.cluster.IsLeader() {
+			return a.cluster.SetMaintenance(addrs, m, 10*time.Second)
+		} else {
+			log.Infof("%s is not leader, skip", a.config().Addr)
+			return ErrNotLeader
+		}
+	} else {
+		a.masters.SetMaintenance(addrs, m)
+	}
+	return nil
+}
+
+// inMaintenance returns true if the master is in maintenance, and leaves the expired maintenance.
+func (a *App) inMaintenance(master string) bool {
+	m := a.masters.GetMaintenance(master)
+	if m == nil {
+		return false
+	}
+
+	if m.Expired(time.Now()) {
+		log.Infof("maintenance of %s expired at %s, leave it", master, m.Until.Format(time.RFC3339))
+		if err := a.LeaveMaintenance([]string{master}); err != nil {
+			log.Errorf("leave maintenance of %s err %v", master, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+func (a *App) onMaintenanceChange(master string, m *Maintenance) {
+	if m != nil {
+		until := "manual"
+		if !m.Until.IsZero() {
+			until = m.Until.Format(time.RFC3339)
+		}
+		log.Infof("master %s enters maintenance, until: %s, reason: %s", master, until, m.Reason)
+		a.events.Publish(&Event{
+			Type:   EventMaintenanceEnter,
+			Master: master,
+			Data:   map[string]interface{}{"until": m.Until, "reason": m.Reason},
+		})
+	} else {
+		log.Infof("master %s leaves maintenance", master)
+		a.events.Publish(&Event{Type: EventMaintenanceLeave, Master: master})
+	}
+}
@@ -0,0 +1,74 @@
+package failover
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type maintenanceTestSuite struct {
+}
+
+var _ = Suite(&maintenanceTestSuite{})
+
+func (s *maintenanceTestSuite) TestMaintenance(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	rs.add(master)
+
+	a := newCheckApp(&Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 2})
+	g := addTestGroup(a, master.Addr, GroupOptions{})
+
+	c.Assert(a.EnterMaintenance([]string{master.Addr}, 0, "upgrade"), IsNil)
+	m := a.masters.GetMaintenance(master.Addr)
+	c.Assert(m, NotNil)
+	c.Assert(m.Reason, Equals, "upgrade")
+	c.Assert(m.Until.IsZero(), Equals, true)
+
+	// the master is alive, the manual maintenance is kept
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(a.masters.GetMaintenance(master.Addr), NotNil)
+
+	// the master is down, but the failover is skipped
+	master.close()
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(g.CheckErrNum.Get(), Equals, int32(1))
+	c.Assert(a.masters.IsMaster(master.Addr), Equals, true)
+	c.Assert(a.masters.GetMaintenance(master.Addr), NotNil)
+
+	// the group is released for the next check
+	c.Assert(g.Acquire(), Equals, true)
+	g.Release()
+
+	// the expired maintenance is left, and we need failover now
+	c.Assert(a.EnterMaintenance([]string{master.Addr}, time.Millisecond, "reboot"), IsNil)
+	c.Assert(a.masters.GetMaintenance(master.Addr).Until.IsZero(), Equals, false)
+	time.Sleep(10 * time.Millisecond)
+
+	c.Assert(a.checkMaster(g), Equals, true)
+	g.Release()
+	c.Assert(a.masters.GetMaintenance(master.Addr), IsNil)
+
+	c.Assert(eventTypes(a.events), DeepEquals, []string{
+		EventMasterAdded,
+		EventMaintenanceEnter,
+		EventSlaveAdded,
+		EventCheckFailed,
+		EventMaintenanceEnter,
+		EventCheckFailed,
+		EventMaintenanceLeave,
+	})
+}
+
+func (s *maintenanceTestSuite) TestExpired(c *C) {
+	now := time.Now()
+
+	m := &Maintenance{Since: now}
+	c.Assert(m.Expired(now.Add(time.Hour)), Equals, false)
+
+	m.Until = now.Add(time.Minute)
+	c.Assert(m.Expired(now), Equals, false)
+	c.Assert(m.Expired(now.Add(time.Minute)), Equals, true)
+}
@@ -0,0 +1,89 @@
+package failover
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type observeTestSuite struct {
+}
+
+var _ = Suite(&observeTestSuite{})
+
+func (s *observeTestSuite) TestObserveOnly(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave1 := rs.add(master)
+	slave2 := rs.add(master)
+
+	slave1.update(func(n *fakeRedis) { n.offset = 10; n.noSync = true })
+	slave2.update(func(n *fakeRedis) { n.offset = 20; n.noSync = true })
+
+	a := newCheckApp(&Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 1, ObserveOnly: true})
+	g := addTestGroup(a, master.Addr, GroupOptions{})
+
+	// find the slaves
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(g.Slaves, HasLen, 2)
+
+	master.close()
+
+	// only report the failover once for the down master
+	for i := 0; i < 3; i++ {
+		c.Assert(a.checkMaster(g), Equals, false)
+	}
+
+	records := a.history.Records()
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Reason, Equals, FailoverReasonObserve)
+	c.Assert(records[0].DryRun, Equals, true)
+	c.Assert(records[0].Master, Equals, master.Addr)
+	c.Assert(records[0].NewMaster, Equals, slave2.Addr)
+	c.Assert(records[0].Detail, Matches, "max priority 100, then max repl_offset 20 in 2 slaves")
+
+	var would []*Event
+	backlog, ch := a.events.Subscribe(0)
+	a.events.Unsubscribe(ch)
+	for _, e := range backlog {
+		if e.Type == EventWouldFailover {
+			would = append(would, e)
+		}
+	}
+	c.Assert(would, HasLen, 1)
+	c.Assert(would[0].Master, Equals, master.Addr)
+	c.Assert(would[0].Node, Equals, slave2.Addr)
+	c.Assert(strings.Contains(would[0].Data["check_error"].(string), ErrNodeDown.Error()), Equals, true)
+
+	// nothing is changed
+	c.Assert(a.masters.GetMasters(), DeepEquals, []string{master.Addr})
+	c.Assert(slave1.commands("SLAVEOF"), HasLen, 0)
+	c.Assert(slave2.commands("SLAVEOF"), HasLen, 0)
+	c.Assert(slave2.commands("REPLICAOF"), HasLen, 0)
+}
+
+func (s *observeTestSuite) TestObserveNotMaster(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave := rs.add(master)
+
+	a := newCheckApp(&Config{Addr: "127.0.0.1:11000", CheckInterval: 1000, MaxDownTime: 1})
+	observe := true
+	g := addTestGroup(a, slave.Addr, GroupOptions{ObserveOnly: &observe})
+
+	// the monitored master is a slave now, keep it
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(g.CheckError(), Equals, ErrNodeType)
+	c.Assert(a.masters.IsMaster(slave.Addr), Equals, true)
+
+	// but remove it without observe only
+	observe = false
+	opts := GroupOptions{ObserveOnly: &observe}
+	g.SetOptions(opts, opts.effective(a.config(), HooksConfig{}))
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(a.masters.IsMaster(slave.Addr), Equals, false)
+}
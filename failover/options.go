@@ -0,0 +1,419 @@
+package failover
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// like redis-sentinel, first check slave priority, then slave repl offset
+	ElectByPriority = "priority"
+	// only check slave repl offset
+	ElectByOffset = "offset"
+)
+
+// GroupOptions overrides the global monitoring policy for one group,
+// the zero value means using the global config.
+// It is saved with the masters in the cluster.
+type GroupOptions struct {
+	// redis, ledis or cluster
+	Backend string `toml:"backend" json:"backend,omitempty"`
+	// Check master alive every n millisecond
+	CheckInterval int `toml:"check_interval" json:"check_interval,omitempty"`
+	// Max down time in seconds before doing failover
+	MaxDownTime int `toml:"max_down_time" json:"max_down_time,omitempty"`
+	// priority or offset
+	ElectStrategy string `toml:"elect_strategy" json:"elect_strategy,omitempty"`
+	// If false, we only report the down master, the failover must be approved with POST /failover
+	AutoFailover *bool `toml:"auto_failover" json:"auto_failover,omitempty"`
+	// If true, we only elect the candidate and report it, never do failover
+	ObserveOnly *bool `toml:"observe_only" json:"observe_only,omitempty"`
+	// Forbid the failover in n seconds after a failover
+	FailoverCooldown int `toml:"failover_cooldown" json:"failover_cooldown,omitempty"`
+	// Wait the candidate to catch up with the most up-to-date slave at most n seconds before promoting
+	CatchUpTimeout int `toml:"catch_up_timeout" json:"catch_up_timeout,omitempty"`
+	// Refuse to promote the candidate if it is behind more than n bytes, unless forced
+	MaxDataLoss int64 `toml:"max_data_loss" json:"max_data_loss,omitempty"`
+	// Verify the replication topology at most n seconds after failover
+	VerifyTimeout int `toml:"verify_timeout" json:"verify_timeout,omitempty"`
+	// If true, CONFIG REWRITE every node changed in failover
+	ConfigRewrite *bool `toml:"config_rewrite" json:"config_rewrite,omitempty"`
+	// none, pause or min_replicas, stop the writes to the old master before promoting
+	Fence string `toml:"fence" json:"fence,omitempty"`
+	// Fence the old master at most n seconds
+	FenceTimeout int `toml:"fence_timeout" json:"fence_timeout,omitempty"`
+	// The slave lagging more than n bytes is unhealthy and can't be elected
+	ReplicaMaxLag int64 `toml:"replica_max_lag" json:"replica_max_lag,omitempty"`
+	// The slave not hearing from master more than n seconds is unhealthy and can't be elected
+	ReplicaMaxLagTime int `toml:"replica_max_lag_time" json:"replica_max_lag_time,omitempty"`
+	// The probes besides ROLE, seperated by comma, like ping,canary,persistence
+	Probes string `toml:"probes" json:"probes,omitempty"`
+	// Max PING latency in millisecond for the ping probe
+	PingMaxLatency int `toml:"ping_max_latency" json:"ping_max_latency,omitempty"`
+	// The key for the canary probe
+	CanaryKey string `toml:"canary_key" json:"canary_key,omitempty"`
+
+	Hooks HooksConfig `toml:"hooks" json:"hooks"`
+}
+
+// GroupConfig is the group defined in config file, the master will be monitored too.
+type GroupConfig struct {
+	Master string `toml:"master" json:"master"`
+	// The slaves used if we haven't found any with ROLE, e.g, the master is down at startup
+	Slaves []string `toml:"slaves" json:"slaves,omitempty"`
+
+	GroupOptions
+}
+
+func (o *GroupOptions) validate() []error {
+	var errs []error
+
+	if err := validateBackend(o.Backend); err != nil {
+		errs = append(errs, err)
+	}
+
+	if o.CheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("check_interval: must not be negative"))
+	}
+
+	if o.MaxDownTime < 0 {
+		errs = append(errs, fmt.Errorf("max_down_time: must not be negative"))
+	}
+
+	if o.FailoverCooldown < 0 {
+		errs = append(errs, fmt.Errorf("failover_cooldown: must not be negative"))
+	}
+
+	if o.CatchUpTimeout < 0 {
+		errs = append(errs, fmt.Errorf("catch_up_timeout: must not be negative"))
+	}
+
+	if o.MaxDataLoss < 0 {
+		errs = append(errs, fmt.Errorf("max_data_loss: must not be negative"))
+	}
+
+	if o.VerifyTimeout < 0 {
+		errs = append(errs, fmt.Errorf("verify_timeout: must not be negative"))
+	}
+
+	if err := validateFence(o.Fence); err != nil {
+		errs = append(errs, err)
+	}
+
+	if o.FenceTimeout < 0 {
+		errs = append(errs, fmt.Errorf("fence_timeout: must not be negative"))
+	}
+
+	if o.ReplicaMaxLag < 0 {
+		errs = append(errs, fmt.Errorf("replica_max_lag: must not be negative"))
+	}
+
+	if o.ReplicaMaxLagTime < 0 {
+		errs = append(errs, fmt.Errorf("replica_max_lag_time: must not be negative"))
+	}
+
+	if _, err := parseProbes(o.Probes); err != nil {
+		errs = append(errs, fmt.Errorf("probes: %v", err))
+	}
+
+	if o.PingMaxLatency < 0 {
+		errs = append(errs, fmt.Errorf("ping_max_latency: must not be negative"))
+	}
+
+	switch o.ElectStrategy {
+	case "", ElectByPriority, ElectByOffset:
+	default:
+		errs = append(errs, fmt.Errorf("elect_strategy: must be %s or %s, not %q", ElectByPriority, ElectByOffset, o.ElectStrategy))
+	}
+
+	if o.Hooks.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("hooks.timeout: must not be negative"))
+	}
+
+	if o.Backend == BackendCluster && len(o.Probes) > 0 && clusterProbes(o.Probes) != o.Probes {
+		errs = append(errs, fmt.Errorf("probes: %s backend doesn't support %s", BackendCluster, ProbeCanary))
+	}
+
+	if o.Backend == BackendLedis {
+		if len(o.Fence) > 0 && o.Fence != FenceNone {
+			errs = append(errs, fmt.Errorf("fence: %s backend can't fence the master", BackendLedis))
+		}
+
+		if len(o.Probes) > 0 && ledisProbes(o.Probes) != o.Probes {
+			errs = append(errs, fmt.Errorf("probes: %s backend only supports %s", BackendLedis, ProbePing))
+		}
+	}
+
+	return errs
+}
+
+func (o *GroupOptions) IsAutoFailover() bool {
+	return o.AutoFailover == nil || *o.AutoFailover
+}
+
+func (o *GroupOptions) IsObserveOnly() bool {
+	return o.ObserveOnly != nil && *o.ObserveOnly
+}
+
+func (o *GroupOptions) IsConfigRewrite() bool {
+	return o.ConfigRewrite != nil && *o.ConfigRewrite
+}
+
+// withoutHooks returns the options saved in cluster, the hooks run shell commands,
+// so they are never saved and replicated, every node uses its local config.
+func (o GroupOptions) withoutHooks() GroupOptions {
+	o.Hooks = HooksConfig{}
+	return o
+}
+
+// equal compares the options by value, the bool options are pointers,
+// the decoded ones are never equal to the saved ones with ==.
+func (o GroupOptions) equal(p GroupOptions) bool {
+	if !boolEqual(o.AutoFailover, p.AutoFailover) || !boolEqual(o.ObserveOnly, p.ObserveOnly) || !boolEqual(o.ConfigRewrite, p.ConfigRewrite) {
+		return false
+	}
+
+	o.AutoFailover, o.ObserveOnly, o.ConfigRewrite = nil, nil, nil
+	p.AutoFailover, p.ObserveOnly, p.ConfigRewrite = nil, nil, nil
+	return o == p
+}
+
+func boolEqual(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// effective returns the options filled with the global config, hooks are the
+// group hooks in local config, the saved hooks are always ignored.
+func (o GroupOptions) effective(c *Config, hooks HooksConfig) GroupOptions {
+	if len(o.Backend) == 0 {
+		o.Backend = c.Backend
+	}
+
+	if len(o.Backend) == 0 {
+		o.Backend = BackendRedis
+	}
+
+	if o.CheckInterval == 0 {
+		o.CheckInterval = c.CheckInterval
+	}
+
+	if o.MaxDownTime == 0 {
+		o.MaxDownTime = c.MaxDownTime
+	}
+
+	if len(o.ElectStrategy) == 0 {
+		o.ElectStrategy = ElectByPriority
+	}
+
+	if o.FailoverCooldown == 0 {
+		o.FailoverCooldown = c.FailoverCooldown
+	}
+
+	if o.CatchUpTimeout == 0 {
+		o.CatchUpTimeout = c.CatchUpTimeout
+	}
+
+	if o.MaxDataLoss == 0 {
+		o.MaxDataLoss = c.MaxDataLoss
+	}
+
+	if o.VerifyTimeout == 0 {
+		o.VerifyTimeout = c.VerifyTimeout
+	}
+
+	if len(o.Fence) == 0 {
+		o.Fence = c.Fence
+	}
+
+	if len(o.Fence) == 0 {
+		o.Fence = FenceNone
+	}
+
+	if o.FenceTimeout == 0 {
+		o.FenceTimeout = c.FenceTimeout
+	}
+
+	if o.ReplicaMaxLag == 0 {
+		o.ReplicaMaxLag = c.ReplicaMaxLag
+	}
+
+	if o.ReplicaMaxLagTime == 0 {
+		o.ReplicaMaxLagTime = c.ReplicaMaxLagTime
+	}
+
+	if len(o.Probes) == 0 {
+		o.Probes = c.Probes
+	}
+
+	if o.PingMaxLatency == 0 {
+		o.PingMaxLatency = c.PingMaxLatency
+	}
+
+	if len(o.CanaryKey) == 0 {
+		o.CanaryKey = c.CanaryKey
+	}
+
+	if o.Backend == BackendLedis {
+		// the global fence and probes may be for the redis groups
+		o.Fence = FenceNone
+		o.Probes = ledisProbes(o.Probes)
+	}
+
+	if o.Backend == BackendCluster {
+		// CLUSTER FAILOVER stops the writes to the alive master by itself,
+		// and a replica can't replicate from another replica to catch up
+		o.Fence = FenceNone
+		o.CatchUpTimeout = 0
+		o.Probes = clusterProbes(o.Probes)
+	}
+
+	auto := o.IsAutoFailover()
+	o.AutoFailover = &auto
+
+	if o.ObserveOnly == nil {
+		observe := c.ObserveOnly
+		o.ObserveOnly = &observe
+	}
+
+	if o.ConfigRewrite == nil {
+		rewrite := c.ConfigRewrite
+		o.ConfigRewrite = &rewrite
+	}
+
+	// the hooks run shell commands, only the local config can set them
+	o.Hooks = hooks
+
+	if len(o.Hooks.BeforeFailover) == 0 {
+		o.Hooks.BeforeFailover = c.Hooks.BeforeFailover
+	}
+
+	if len(o.Hooks.AfterFailover) == 0 {
+		o.Hooks.AfterFailover = c.Hooks.AfterFailover
+	}
+
+	if o.Hooks.Timeout == 0 {
+		o.Hooks.Timeout = c.Hooks.Timeout
+	}
+
+	return o
+}
+
+// parseGroupOptions parses the options from HTTP form values.
+func parseGroupOptions(form url.Values) (*GroupOptions, error) {
+	o := new(GroupOptions)
+
+	var err error
+	if v := form.Get("check_interval"); len(v) > 0 {
+		if o.CheckInterval, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid check_interval %s", v)
+		}
+	}
+
+	if v := form.Get("max_down_time"); len(v) > 0 {
+		if o.MaxDownTime, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid max_down_time %s", v)
+		}
+	}
+
+	if v := form.Get("failover_cooldown"); len(v) > 0 {
+		if o.FailoverCooldown, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid failover_cooldown %s", v)
+		}
+	}
+
+	if v := form.Get("catch_up_timeout"); len(v) > 0 {
+		if o.CatchUpTimeout, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid catch_up_timeout %s", v)
+		}
+	}
+
+	if v := form.Get("max_data_loss"); len(v) > 0 {
+		if o.MaxDataLoss, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid max_data_loss %s", v)
+		}
+	}
+
+	if v := form.Get("verify_timeout"); len(v) > 0 {
+		if o.VerifyTimeout, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid verify_timeout %s", v)
+		}
+	}
+
+	if v := form.Get("fence_timeout"); len(v) > 0 {
+		if o.FenceTimeout, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid fence_timeout %s", v)
+		}
+	}
+
+	if v := form.Get("ping_max_latency"); len(v) > 0 {
+		if o.PingMaxLatency, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid ping_max_latency %s", v)
+		}
+	}
+
+	if v := form.Get("replica_max_lag"); len(v) > 0 {
+		if o.ReplicaMaxLag, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid replica_max_lag %s", v)
+		}
+	}
+
+	if v := form.Get("replica_max_lag_time"); len(v) > 0 {
+		if o.ReplicaMaxLagTime, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid replica_max_lag_time %s", v)
+		}
+	}
+
+	o.Backend = form.Get("backend")
+	o.ElectStrategy = form.Get("elect_strategy")
+	o.Fence = form.Get("fence")
+	o.Probes = form.Get("probes")
+	o.CanaryKey = form.Get("canary_key")
+
+	if v := form.Get("auto_failover"); len(v) > 0 {
+		auto, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auto_failover %s", v)
+		}
+		o.AutoFailover = &auto
+	}
+
+	if v := form.Get("observe_only"); len(v) > 0 {
+		observe, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid observe_only %s", v)
+		}
+		o.ObserveOnly = &observe
+	}
+
+	if v := form.Get("config_rewrite"); len(v) > 0 {
+		rewrite, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config_rewrite %s", v)
+		}
+		o.ConfigRewrite = &rewrite
+	}
+
+	// the hooks run shell commands, never accept them from HTTP
+	if len(form.Get("before_failover")) > 0 || len(form.Get("after_failover")) > 0 {
+		return nil, fmt.Errorf("hooks can only be set in config file")
+	}
+
+	if errs := o.validate(); len(errs) > 0 {
+		return nil, ConfigError(errs)
+	}
+
+	return o, nil
+}
+
+func validateFence(fence string) error {
+	switch fence {
+	case "", FenceNone, FencePause, FenceMinReplicas:
+		return nil
+	default:
+		return fmt.Errorf("fence: must be %s, %s or %s, not %q", FenceNone, FencePause, FenceMinReplicas, fence)
+	}
+}
@@ -0,0 +1,157 @@
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/go/log"
+)
+
+// The probes checking the master besides ROLE.
+const (
+	// PING latency must be less than ping_max_latency
+	ProbePing = "ping"
+	// write and read back the canary key
+	ProbeCanary = "canary"
+	// rdb_last_bgsave_status and aof_last_write_status in INFO persistence must be ok
+	ProbePersistence = "persistence"
+)
+
+const defaultCanaryKey = "redis-failover:canary"
+
+var (
+	ErrNodeLoading  = errors.New("Node is loading the dataset")
+	ErrNodeBusy     = errors.New("Node is busy running a script")
+	ErrMasterDown   = errors.New("Node replies MASTERDOWN")
+	ErrSlowPing     = errors.New("Node replies PING too slowly")
+	ErrCanary       = errors.New("Node can't write and read the canary key")
+	ErrPersistence  = errors.New("Node persistence failed")
+	ErrUnknownProbe = errors.New("unknown probe")
+)
+
+// replyError returns the reason why the command failed.
+func replyError(err error) error {
+	if e, ok := err.(redis.Error); ok {
+		switch {
+		case strings.HasPrefix(string(e), "LOADING"):
+			return ErrNodeLoading
+		case strings.HasPrefix(string(e), "BUSY"):
+			return ErrNodeBusy
+		case strings.HasPrefix(string(e), "MASTERDOWN"):
+			return ErrMasterDown
+		}
+	}
+
+	return ErrNodeDown
+}
+
+// masterReachable returns true if the master failed the check but can still be reached,
+// the failover must promote a slave like switchover.
+func masterReachable(err error) bool {
+	switch err {
+	case ErrNodeBusy, ErrSlowPing, ErrCanary, ErrPersistence:
+		return true
+	}
+	return false
+}
+
+func parseProbes(probes string) ([]string, error) {
+	var ps []string
+	for _, p := range strings.Split(probes, ",") {
+		p = strings.TrimSpace(p)
+		switch p {
+		case "":
+			continue
+		case ProbePing, ProbeCanary, ProbePersistence:
+			ps = append(ps, p)
+		default:
+			return nil, fmt.Errorf("%v %q, must be %s, %s or %s", ErrUnknownProbe, p, ProbePing, ProbeCanary, ProbePersistence)
+		}
+	}
+	return ps, nil
+}
+
+// doProbes runs the probes of the group after ROLE, the caller must hold the group lock.
+func (g *Group) doProbes() error {
+	_, opts := g.Options()
+
+	probes, _ := parseProbes(opts.Probes)
+	for _, p := range probes {
+		var err error
+		switch p {
+		case ProbePing:
+			err = g.probePing(opts.PingMaxLatency)
+		case ProbeCanary:
+			err = g.probeCanary(opts.CanaryKey)
+		case ProbePersistence:
+			err = g.probePersistence()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *Group) probePing(maxLatency int) error {
+	t := time.Now()
+	if _, err := g.Master.doCommand("PING"); err != nil {
+		return replyError(err)
+	}
+
+	latency := time.Since(t)
+	if maxLatency > 0 && latency > time.Duration(maxLatency)*time.Millisecond {
+		log.Errorf("master %s PING latency %s, more than %dms", g.Master.Addr, latency, maxLatency)
+		return ErrSlowPing
+	}
+	return nil
+}
+
+func (g *Group) probeCanary(key string) error {
+	if len(key) == 0 {
+		key = defaultCanaryKey
+	}
+
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if _, err := g.Master.doCommand("SET", key, value, "PX", 60000); err != nil {
+		if _, ok := err.(redis.Error); !ok {
+			return ErrNodeDown
+		}
+
+		if e := replyError(err); e != ErrNodeDown {
+			return e
+		}
+
+		// like READONLY or OOM
+		log.Errorf("master %s write canary key %s err %v", g.Master.Addr, key, err)
+		return ErrCanary
+	}
+
+	v, err := redis.String(g.Master.doCommand("GET", key))
+	if err != nil || v != value {
+		log.Errorf("master %s read canary key %s got %q, err %v", g.Master.Addr, key, v, err)
+		return ErrCanary
+	}
+	return nil
+}
+
+func (g *Group) probePersistence() error {
+	m, err := doInfo(g.Master.doCommand, "PERSISTENCE")
+	if err != nil {
+		return replyError(err)
+	}
+
+	for _, name := range []string{"rdb_last_bgsave_status", "aof_last_write_status", "aof_last_bgrewrite_status"} {
+		if v, ok := m[name]; ok && v != "ok" {
+			log.Errorf("master %s %s is %s", g.Master.Addr, name, v)
+			return ErrPersistence
+		}
+	}
+	return nil
+}
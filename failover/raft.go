@@ -1,8 +1,8 @@
 package failover
 
 import (
-	"encoding/json"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"time"
@@ -13,55 +13,54 @@ import (
 )
 
 func (fsm *masterFSM) Apply(l *raft.Log) interface{} {
-	var a action
-	if err := json.Unmarshal(l.Data, &a); err != nil {
+	a, err := decodeCommand(l.Data)
+	if err != nil {
 		log.Errorf("decode raft log err %v", err)
 		return err
 	}
 
-	fsm.handleAction(&a)
+	fsm.handleAction(a)
 
 	return nil
 }
 
 func (fsm *masterFSM) Snapshot() (raft.FSMSnapshot, error) {
-	snap := new(masterSnapshot)
-	snap.masters = make([]string, 0, len(fsm.masters))
-
-	fsm.Lock()
-	for master := range fsm.masters {
-		snap.masters = append(snap.masters, master)
-	}
-	fsm.Unlock()
-	return snap, nil
+	return fsm.snapshot(), nil
 }
 
+// Restore replaces the whole state with the snapshot,
+// the masters deleted after the snapshot must not be kept.
 func (fsm *masterFSM) Restore(snap io.ReadCloser) error {
 	defer snap.Close()
 
-	d := json.NewDecoder(snap)
-	var masters []string
-
-	if err := d.Decode(&masters); err != nil {
+	data, err := ioutil.ReadAll(snap)
+	if err != nil {
 		return err
 	}
 
-	fsm.Lock()
-	for _, master := range masters {
-		fsm.masters[master] = struct{}{}
+	s, err := decodeSnapshot(data)
+	if err != nil {
+		log.Errorf("decode raft snapshot err %v", err)
+		return err
 	}
-	fsm.Unlock()
 
+	fsm.restore(s)
 	return nil
 }
 
 type masterSnapshot struct {
-	masters []string
+	Masters     []string                `json:"masters"`
+	Options     map[string]GroupOptions `json:"options,omitempty"`
+	Maintenance map[string]Maintenance  `json:"maintenance,omitempty"`
+	Slaves      map[string][]string     `json:"slaves,omitempty"`
 }
 
 func (snap *masterSnapshot) Persist(sink raft.SnapshotSink) error {
-	data, _ := json.Marshal(snap.masters)
-	_, err := sink.Write(data)
+	data, err := encodeSnapshot(snap)
+	if err == nil {
+		_, err = sink.Write(data)
+	}
+
 	if err != nil {
 		sink.Cancel()
 	}
@@ -188,9 +187,14 @@ func (r *Raft) Close() {
 }
 
 func (r *Raft) AddMasters(addrs []string, timeout time.Duration) error {
+	return r.AddMastersWithOptions(addrs, nil, timeout)
+}
+
+func (r *Raft) AddMastersWithOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
 	var a = action{
 		Cmd:     addCmd,
 		Masters: addrs,
+		Options: opts,
 	}
 
 	return r.apply(&a, timeout)
@@ -214,6 +218,36 @@ func (r *Raft) SetMasters(addrs []string, timeout time.Duration) error {
 	return r.apply(&a, timeout)
 }
 
+func (r *Raft) SetGroupOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
+	var a = action{
+		Cmd:     optCmd,
+		Masters: addrs,
+		Options: opts,
+	}
+
+	return r.apply(&a, timeout)
+}
+
+func (r *Raft) SetMaintenance(addrs []string, m *Maintenance, timeout time.Duration) error {
+	var a = action{
+		Cmd:         maintCmd,
+		Masters:     addrs,
+		Maintenance: m,
+	}
+
+	return r.apply(&a, timeout)
+}
+
+func (r *Raft) SetSlaves(master string, slaves []string, timeout time.Duration) error {
+	var a = action{
+		Cmd:     slaveCmd,
+		Masters: []string{master},
+		Slaves:  slaves,
+	}
+
+	return r.apply(&a, timeout)
+}
+
 func (r *Raft) AddPeer(peerAddr string) error {
 	f := r.r.AddPeer(peerAddr)
 	return f.Error()
@@ -245,7 +279,7 @@ func (r *Raft) GetPeers() ([]string, error) {
 }
 
 func (r *Raft) apply(a *action, timeout time.Duration) error {
-	data, err := json.Marshal(a)
+	data, err := encodeCommand(a)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,300 @@
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/siddontang/go/log"
+)
+
+// wait the replica to be promoted by CLUSTER FAILOVER at most n
+const clusterFailoverTimeout = 5 * time.Second
+
+// the dial, read and write timeout for CLUSTER NODES from a seed
+const clusterSeedTimeout = 2 * time.Second
+
+var ErrClusterSlaveOf = errors.New("can't promote with SLAVEOF NO ONE in redis cluster, use CLUSTER FAILOVER")
+
+// clusterNode is a line of CLUSTER NODES.
+type clusterNode struct {
+	ID     string
+	Addr   string
+	Flags  []string
+	Master string
+	// the slot ranges, like 0-5460
+	Slots []string
+}
+
+func (n *clusterNode) hasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClusterNodes parses the CLUSTER NODES reply, every line is
+// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func parseClusterNodes(v string) []*clusterNode {
+	var nodes []*clusterNode
+	for _, line := range strings.Split(v, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+
+		n := &clusterNode{ID: fields[0], Master: fields[3]}
+
+		// the cluster bus port and hostname are only in redis 4.0 and later
+		addr := fields[1]
+		if i := strings.IndexAny(addr, "@,"); i >= 0 {
+			addr = addr[:i]
+		}
+		n.Addr = addr
+
+		n.Flags = strings.Split(fields[2], ",")
+
+		for _, slot := range fields[8:] {
+			// skip the importing and migrating slots like [1000->-id]
+			if !strings.HasPrefix(slot, "[") {
+				n.Slots = append(n.Slots, slot)
+			}
+		}
+
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func doClusterNodes(do doFunc) ([]*clusterNode, error) {
+	v, err := redis.String(do("CLUSTER", "NODES"))
+	if err != nil {
+		return nil, err
+	}
+	return parseClusterNodes(v), nil
+}
+
+// clusterMasters returns the shard masters which can serve, with their slots.
+func clusterMasters(nodes []*clusterNode) map[string]string {
+	masters := make(map[string]string)
+	for _, n := range nodes {
+		if !n.hasFlag(MasterType) || n.hasFlag("noaddr") || n.hasFlag("handshake") || strings.HasPrefix(n.Addr, ":") {
+			continue
+		}
+
+		// the master without slots is not a shard
+		if len(n.Slots) == 0 {
+			continue
+		}
+
+		masters[n.Addr] = strings.Join(n.Slots, " ")
+	}
+	return masters
+}
+
+// clusterBackend monitors the shard masters of redis cluster, it uses ROLE and INFO like redis,
+// but promotes the replica with CLUSTER FAILOVER, the other replicas and the old master
+// follow the new master automatically.
+type clusterBackend struct {
+	redisBackend
+}
+
+// SlaveOf lets the node replicate from the master host:port with CLUSTER REPLICATE.
+func (clusterBackend) SlaveOf(do doFunc, host string, port string) error {
+	if strings.ToLower(host) == "no" && strings.ToLower(port) == "one" {
+		return ErrClusterSlaveOf
+	}
+
+	nodes, err := doClusterNodes(do)
+	if err != nil {
+		return err
+	}
+
+	addr := fmt.Sprintf("%s:%s", host, port)
+	for _, n := range nodes {
+		if n.Addr == addr {
+			_, err = do("CLUSTER", "REPLICATE", n.ID)
+			return err
+		}
+	}
+
+	return fmt.Errorf("%s is not in the cluster", addr)
+}
+
+// Promote promotes the replica with CLUSTER FAILOVER if the master is alive,
+// or CLUSTER FAILOVER FORCE, then TAKEOVER if the majority of masters can't agree.
+func (clusterBackend) Promote(do doFunc, masterAlive bool) error {
+	modes := []string{"FORCE", "TAKEOVER"}
+	if masterAlive {
+		modes = []string{""}
+	}
+
+	var err error
+	for _, mode := range modes {
+		args := []interface{}{"FAILOVER"}
+		if len(mode) > 0 {
+			args = append(args, mode)
+		}
+
+		if _, err = do("CLUSTER", args...); err != nil {
+			return err
+		}
+
+		// CLUSTER FAILOVER only starts the failover
+		if err = waitClusterMaster(do, clusterFailoverTimeout); err == nil {
+			return nil
+		}
+
+		log.Errorf("cluster failover %s err %v", mode, err)
+	}
+
+	return err
+}
+
+func waitClusterMaster(do doFunc, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes, err := doClusterNodes(do)
+		if err == nil {
+			if n := clusterMyself(nodes); n != nil && n.hasFlag(MasterType) {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not promoted to master in %s", timeout)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func clusterMyself(nodes []*clusterNode) *clusterNode {
+	for _, n := range nodes {
+		if n.hasFlag("myself") {
+			return n
+		}
+	}
+	return nil
+}
+
+// MasterSlots returns the slots of the node's master for a replica, or its own slots for a master.
+func (clusterBackend) MasterSlots(do doFunc) (string, error) {
+	nodes, err := doClusterNodes(do)
+	if err != nil {
+		return "", err
+	}
+
+	myself := clusterMyself(nodes)
+	if myself == nil {
+		return "", fmt.Errorf("no myself in CLUSTER NODES")
+	}
+
+	if myself.hasFlag(MasterType) {
+		return strings.Join(myself.Slots, " "), nil
+	}
+
+	for _, n := range nodes {
+		if n.ID == myself.Master {
+			return strings.Join(n.Slots, " "), nil
+		}
+	}
+	return "", fmt.Errorf("master %s is not in CLUSTER NODES", myself.Master)
+}
+
+// refreshRedisCluster finds the shard masters from the seeds in background, so a hung seed
+// can't stall the check loop, and it is skipped if the last refresh is still running.
+func (a *App) refreshRedisCluster(c *Config, now time.Time) {
+	rc := c.RedisCluster
+	if len(rc.Seeds) == 0 || now.Sub(a.clusterRefreshed) < time.Duration(rc.RefreshInterval)*time.Second {
+		return
+	}
+
+	if !a.clusterRefreshing.CompareAndSwap(0, 1) {
+		log.Warnf("last redis cluster refresh is still running, skip")
+		return
+	}
+	a.clusterRefreshed = now
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer a.clusterRefreshing.Set(0)
+
+		a.doRefreshRedisCluster(rc.Seeds)
+	}()
+}
+
+// doRefreshRedisCluster monitors the new shard masters found from the seeds,
+// the demoted masters are removed by the check like other groups.
+func (a *App) doRefreshRedisCluster(seeds []string) {
+	var nodes []*clusterNode
+	var err error
+	for _, seed := range seeds {
+		nodes, err = seedClusterNodes(seed)
+		if err == nil {
+			break
+		}
+		log.Errorf("get cluster nodes from seed %s err %v", seed, err)
+	}
+
+	if err != nil {
+		return
+	}
+
+	masters := clusterMasters(nodes)
+
+	a.gMutex.Lock()
+	a.shards = masters
+	a.gMutex.Unlock()
+
+	var added []string
+	for addr := range masters {
+		if !a.masters.IsMaster(addr) {
+			added = append(added, addr)
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	sort.Strings(added)
+	log.Infof("find redis cluster shard masters %v", added)
+
+	// the new shard masters use the cluster backend, set in the same command, or the check
+	// before setting may use the redis backend and fail over the shard with SLAVEOF NO ONE
+	if err := a.addMastersWithOptions(added, &GroupOptions{Backend: BackendCluster}); err != nil {
+		log.Errorf("add redis cluster masters %v err %v", added, err)
+	}
+}
+
+// seedClusterNodes gets CLUSTER NODES from the seed once, with short timeouts.
+func seedClusterNodes(seed string) ([]*clusterNode, error) {
+	conn, err := dialAddr(seed, clusterSeedTimeout, clusterSeedTimeout, clusterSeedTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return doClusterNodes(conn.Do)
+}
+
+// clusterProbes drops the canary probe, the canary key is owned by only one shard,
+// other shards reply MOVED.
+func clusterProbes(probes string) string {
+	ps, _ := parseProbes(probes)
+
+	var s []string
+	for _, p := range ps {
+		if p != ProbeCanary {
+			s = append(s, p)
+		}
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,125 @@
+package failover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/siddontang/go/log"
+)
+
+// SetConfigLoader sets the function to load the config for reloading,
+// if not set, we will load the config from the file the current config loaded from.
+func (a *App) SetConfigLoader(f func() (*Config, error)) {
+	a.cMutex.Lock()
+	defer a.cMutex.Unlock()
+
+	a.configLoader = f
+}
+
+// Reload loads the config again and applies it.
+func (a *App) Reload() error {
+	a.cMutex.RLock()
+	loader := a.configLoader
+	name := a.c.FileName
+	a.cMutex.RUnlock()
+
+	var c *Config
+	var err error
+	if loader != nil {
+		c, err = loader()
+	} else if len(name) > 0 {
+		c, err = NewConfigWithFile(name)
+	} else {
+		err = fmt.Errorf("no config file to reload")
+	}
+
+	if err != nil {
+		log.Errorf("reload config err %v", err)
+		return err
+	}
+
+	return a.ReloadConfig(c)
+}
+
+// ReloadConfig applies the new config without restart, only check_interval, max_down_time,
+// log_level, hooks, masters and groups can be changed, if other fields changed, we will reject it.
+func (a *App) ReloadConfig(c *Config) error {
+	if err := c.Validate(); err != nil {
+		log.Errorf("reload config rejected, %v", err)
+		return err
+	}
+
+	c.adjust()
+
+	a.cMutex.Lock()
+	old := a.c
+
+	if fields := old.restartFields(c); len(fields) > 0 {
+		a.cMutex.Unlock()
+		err := fmt.Errorf("reload config rejected, %s changed, must restart", strings.Join(fields, ","))
+		log.Errorf("%v", err)
+		return err
+	}
+
+	a.c = c
+	a.cMutex.Unlock()
+
+	if c.LogLevel != old.LogLevel && len(c.LogLevel) > 0 {
+		log.SetLevelByName(c.LogLevel)
+	}
+
+	if c.CheckInterval != old.CheckInterval {
+		select {
+		case a.reloadCh <- struct{}{}:
+		default:
+		}
+	}
+
+	added, removed := diffStrings(old.allMasters(), c.allMasters())
+	if err := a.addMasters(added); err != nil {
+		log.Errorf("reload config, add masters %v err %v", added, err)
+		return err
+	}
+
+	if err := a.delMasters(removed); err != nil {
+		log.Errorf("reload config, delete masters %v err %v", removed, err)
+		return err
+	}
+
+	if err := a.applyGroupConfigs(old.Groups, c.Groups); err != nil {
+		log.Errorf("reload config, apply groups err %v", err)
+		return err
+	}
+
+	log.Infof("reload config ok, check_interval: %d, max_down_time: %d, added masters: %v, removed masters: %v",
+		c.CheckInterval, c.MaxDownTime, added, removed)
+	return nil
+}
+
+// diffStrings returns the items in b but not in a, and the items in a but not in b.
+func diffStrings(a []string, b []string) ([]string, []string) {
+	am := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		am[s] = struct{}{}
+	}
+
+	bm := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		bm[s] = struct{}{}
+	}
+
+	var added, removed []string
+	for _, s := range b {
+		if _, ok := am[s]; !ok {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range a {
+		if _, ok := bm[s]; !ok {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
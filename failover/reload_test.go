@@ -0,0 +1,85 @@
+package failover
+
+import (
+	"sort"
+
+	. "gopkg.in/check.v1"
+)
+
+type reloadTestSuite struct {
+}
+
+var _ = Suite(&reloadTestSuite{})
+
+func (s *reloadTestSuite) TestDiffStrings(c *C) {
+	added, removed := diffStrings([]string{"a", "b", "c"}, []string{"b", "d", "c", "e"})
+	c.Assert(added, DeepEquals, []string{"d", "e"})
+	c.Assert(removed, DeepEquals, []string{"a"})
+
+	added, removed = diffStrings(nil, []string{"a"})
+	c.Assert(added, DeepEquals, []string{"a"})
+	c.Assert(removed, IsNil)
+
+	added, removed = diffStrings([]string{"a", "b"}, []string{"b", "a"})
+	c.Assert(added, IsNil)
+	c.Assert(removed, IsNil)
+}
+
+func sortedMasters(a *App) []string {
+	masters := a.masters.GetMasters()
+	sort.Strings(masters)
+	return masters
+}
+
+func (s *reloadTestSuite) TestReload(c *C) {
+	old := &Config{
+		Addr:    "127.0.0.1:11000",
+		Masters: []string{"127.0.0.1:6379"},
+		Groups:  []GroupConfig{{Master: "127.0.0.1:6380", GroupOptions: GroupOptions{MaxDownTime: 5}}},
+	}
+
+	a := newDiscoveryApp(old)
+	a.reloadCh = make(chan struct{}, 1)
+	a.masters.AddMasters(old.allMasters())
+	c.Assert(a.applyGroupConfigs(nil, old.Groups), IsNil)
+
+	// no config file
+	c.Assert(a.Reload(), NotNil)
+
+	cfg := &Config{
+		Addr:          "127.0.0.1:11000",
+		CheckInterval: 500,
+		Masters:       []string{"127.0.0.1:6379", "127.0.0.1:6381"},
+		Groups:        []GroupConfig{{Master: "127.0.0.1:6382", GroupOptions: GroupOptions{ElectStrategy: ElectByOffset}}},
+	}
+	a.SetConfigLoader(func() (*Config, error) { return cfg, nil })
+	c.Assert(a.Reload(), IsNil)
+
+	c.Assert(a.config(), Equals, cfg)
+	c.Assert(sortedMasters(a), DeepEquals, []string{"127.0.0.1:6379", "127.0.0.1:6381", "127.0.0.1:6382"})
+	c.Assert(a.masters.GetOptions("127.0.0.1:6382"), Equals, GroupOptions{ElectStrategy: ElectByOffset})
+
+	// the check loop is told to use the new interval
+	select {
+	case <-a.reloadCh:
+	default:
+		c.Fatal("check interval changed, but the check loop is not notified")
+	}
+
+	// the options are removed with the group
+	cfg = &Config{Addr: "127.0.0.1:11000", Masters: []string{"127.0.0.1:6382"}}
+	c.Assert(a.ReloadConfig(cfg), IsNil)
+	c.Assert(sortedMasters(a), DeepEquals, []string{"127.0.0.1:6382"})
+	c.Assert(a.masters.GetAllOptions(), HasLen, 0)
+
+	// the listen address can't be changed without restart
+	err := a.ReloadConfig(&Config{Addr: "127.0.0.1:11001", Masters: []string{"127.0.0.1:6383"}})
+	c.Assert(err, ErrorMatches, ".*addr changed, must restart")
+	c.Assert(a.config(), Equals, cfg)
+	c.Assert(sortedMasters(a), DeepEquals, []string{"127.0.0.1:6382"})
+
+	// the invalid config is rejected
+	err = a.ReloadConfig(&Config{Addr: "127.0.0.1:11000", Masters: []string{"127.0.0.1:abc"}})
+	c.Assert(err, FitsTypeOf, ConfigError{})
+	c.Assert(a.config(), Equals, cfg)
+}
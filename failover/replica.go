@@ -0,0 +1,132 @@
+package failover
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+// the slave probe must not slow down the master check
+const probeTimeout = time.Second
+
+// ReplicaHealth is the replication state of a slave, probed in every check.
+type ReplicaHealth struct {
+	Healthy    bool  `json:"healthy"`
+	Reachable  bool  `json:"reachable"`
+	LinkUp     bool  `json:"link_up"`
+	Syncing    bool  `json:"syncing"`
+	LagBytes   int64 `json:"lag_bytes"`
+	LagSeconds int   `json:"lag_seconds"`
+	// why it is unhealthy
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// probe gets the replication info of the slave, only try once.
+func (n *Node) probe(b Backend) (*ReplInfo, error) {
+	if n.probeConn == nil {
+		var err error
+		n.probeConn, err = dialAddr(n.Addr, probeTimeout, probeTimeout, probeTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := b.ReplInfo(n.probeConn.Do)
+	if err != nil {
+		n.probeConn.Close()
+		n.probeConn = nil
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// replicaHealth checks the replication info of the slave with the master offset.
+func replicaHealth(info *ReplInfo, masterOffset int64, opts *GroupOptions) *ReplicaHealth {
+	h := &ReplicaHealth{Reachable: true, Time: time.Now()}
+
+	h.LinkUp = info.LinkUp
+	h.Syncing = info.Syncing
+
+	if h.LagBytes = masterOffset - info.Offset; h.LagBytes < 0 {
+		h.LagBytes = 0
+	}
+
+	// -1 if the link is down or unknown
+	h.LagSeconds = info.LastIOSeconds
+
+	switch {
+	case info.Role != SlaveType:
+		h.Error = fmt.Sprintf("role is %s", info.Role)
+	case !h.LinkUp:
+		h.Error = "master link is down"
+	case h.Syncing:
+		h.Error = "sync is in progress"
+	case opts.ReplicaMaxLag > 0 && h.LagBytes > opts.ReplicaMaxLag:
+		h.Error = fmt.Sprintf("lag %d bytes, more than replica_max_lag %d", h.LagBytes, opts.ReplicaMaxLag)
+	case opts.ReplicaMaxLagTime > 0 && h.LagSeconds > opts.ReplicaMaxLagTime:
+		h.Error = fmt.Sprintf("lag %d seconds, more than replica_max_lag_time %d", h.LagSeconds, opts.ReplicaMaxLagTime)
+	default:
+		h.Healthy = true
+	}
+
+	return h
+}
+
+// checkSlaves probes all slaves concurrently and reports the health changes,
+// the caller must hold the group lock.
+func (g *Group) checkSlaves() {
+	_, opts := g.Options()
+
+	masterOffset := g.Master.Offset
+	b := g.backend()
+
+	var wg sync.WaitGroup
+	healths := make([]*ReplicaHealth, 0, len(g.Slaves))
+	slaves := make([]*Node, 0, len(g.Slaves))
+	for _, slave := range g.Slaves {
+		slaves = append(slaves, slave)
+		healths = append(healths, nil)
+	}
+
+	for i, slave := range slaves {
+		wg.Add(1)
+		go func(i int, slave *Node) {
+			defer wg.Done()
+
+			info, err := slave.probe(b)
+			if err != nil {
+				healths[i] = &ReplicaHealth{Error: err.Error(), Time: time.Now()}
+				return
+			}
+			healths[i] = replicaHealth(info, masterOffset, &opts)
+		}(i, slave)
+	}
+
+	wg.Wait()
+
+	for i, slave := range slaves {
+		old, h := slave.Health, healths[i]
+		slave.Health = h
+
+		if h.Healthy == (old == nil || old.Healthy) {
+			continue
+		}
+
+		if h.Healthy {
+			log.Infof("slave %s of master %s is healthy now", slave.Addr, g.Master.Addr)
+			g.events.Publish(&Event{Type: EventSlaveHealthy, Master: g.Master.Addr, Node: slave.Addr})
+		} else {
+			log.Errorf("slave %s of master %s is unhealthy, %s", slave.Addr, g.Master.Addr, h.Error)
+			g.events.Publish(&Event{
+				Type:   EventSlaveUnhealthy,
+				Master: g.Master.Addr,
+				Node:   slave.Addr,
+				Data:   map[string]interface{}{"error": h.Error, "lag_bytes": h.LagBytes, "lag_seconds": h.LagSeconds},
+			})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package failover
+
+import (
+	"sort"
+
+	. "gopkg.in/check.v1"
+)
+
+type slavesTestSuite struct {
+}
+
+var _ = Suite(&slavesTestSuite{})
+
+func (s *slavesTestSuite) TestSeedSlaves(c *C) {
+	g := newGroup("127.0.0.1:6379")
+
+	// the master itself is never a slave
+	g.SeedSlaves([]string{"127.0.0.1:6380", "127.0.0.1:6379"})
+	_, slaves := g.SlaveAddrs()
+	c.Assert(slaves, DeepEquals, []string{"127.0.0.1:6380"})
+
+	// the slaves found with ROLE are not overridden
+	g.SeedSlaves([]string{"127.0.0.1:6381"})
+	_, slaves = g.SlaveAddrs()
+	c.Assert(slaves, DeepEquals, []string{"127.0.0.1:6380"})
+}
+
+func (s *slavesTestSuite) TestSaveSlaves(c *C) {
+	rs := newFakeRedisSet()
+	defer rs.close()
+
+	master := rs.add(nil)
+	slave1 := rs.add(master)
+	slave2 := rs.add(master)
+
+	want := []string{slave1.Addr, slave2.Addr}
+	sort.Strings(want)
+
+	cfg := &Config{
+		Addr:          "127.0.0.1:11000",
+		CheckInterval: 1000,
+		MaxDownTime:   1,
+		Groups:        []GroupConfig{{Master: master.Addr, Slaves: []string{"127.0.0.1:6390"}}},
+	}
+
+	a := newCheckApp(cfg)
+	fc := &fakeCluster{fsm: a.masters, leader: true}
+	a.cluster = fc
+
+	// no slave is saved yet, use the slaves in config
+	c.Assert(a.knownSlaves(cfg, master.Addr), DeepEquals, []string{"127.0.0.1:6390"})
+
+	g := addTestGroup(a, master.Addr, GroupOptions{})
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(a.masters.GetSlaves(master.Addr), DeepEquals, want)
+	c.Assert(a.knownSlaves(cfg, master.Addr), DeepEquals, want)
+	c.Assert(fc.slaveWrites, Equals, 1)
+
+	// nothing is written if the slaves are not changed
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(fc.slaveWrites, Equals, 1)
+
+	slave2.update(func(n *fakeRedis) { n.master = "" })
+	c.Assert(a.checkMaster(g), Equals, false)
+	c.Assert(a.masters.GetSlaves(master.Addr), DeepEquals, []string{slave1.Addr})
+	c.Assert(fc.slaveWrites, Equals, 2)
+
+	// the saved slaves are in the snapshot, so a new leader knows them
+	fsm := newMasterFSM()
+	fsm.restore(a.masters.snapshot())
+	c.Assert(fsm.GetSlaves(master.Addr), DeepEquals, []string{slave1.Addr})
+
+	// the master is down before the first check of the new leader,
+	// we can still elect the saved slave
+	slave1.update(func(n *fakeRedis) { n.linkDown = true })
+	master.close()
+
+	b := newCheckApp(cfg)
+	b.masters = fsm
+	ng := addTestGroup(b, master.Addr, GroupOptions{})
+	c.Assert(b.checkMaster(ng), Equals, true)
+	defer ng.Release()
+
+	addr, err := ng.Elect()
+	c.Assert(err, IsNil)
+	c.Assert(addr, Equals, slave1.Addr)
+}
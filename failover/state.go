@@ -0,0 +1,180 @@
+package failover
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The raft log and snapshot are versioned, the old data is migrated to the current version
+// step by step when decoding, so the new fields can be added without breaking the data dir.
+//
+// version 0, before versioning, the log is the bare action {"cmd": "add", "masters": [...]},
+// the snapshot is the masters array.
+//
+// version 1, the log is {"version": 1, "cmd": "add", "data": {"masters": [...]}},
+// the snapshot is {"version": 1, "state": {"masters": [...], "options": {...}}}.
+const stateVersion = 1
+
+// command is the envelope of a raft log.
+type command struct {
+	Version int             `json:"version"`
+	Cmd     string          `json:"cmd"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// commandData is the payload of the command, the fields are used by some commands only.
+type commandData struct {
+	Masters     []string      `json:"masters"`
+	Options     *GroupOptions `json:"options,omitempty"`
+	Maintenance *Maintenance  `json:"maintenance,omitempty"`
+	Slaves      []string      `json:"slaves,omitempty"`
+}
+
+// snapshotEnvelope is the envelope of a raft snapshot, the state is a masterSnapshot.
+type snapshotEnvelope struct {
+	Version int             `json:"version"`
+	State   json.RawMessage `json:"state"`
+}
+
+// commandMigrations[i] migrates the command from version i to i+1.
+var commandMigrations = []func(data []byte) ([]byte, error){
+	migrateCommandV0,
+}
+
+// snapshotMigrations[i] migrates the snapshot from version i to i+1.
+var snapshotMigrations = []func(data []byte) ([]byte, error){
+	migrateSnapshotV0,
+}
+
+func migrateCommandV0(data []byte) ([]byte, error) {
+	var a struct {
+		Cmd string `json:"cmd"`
+		commandData
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+
+	d, err := json.Marshal(a.commandData)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(command{Version: 1, Cmd: a.Cmd, Data: d})
+}
+
+func migrateSnapshotV0(data []byte) ([]byte, error) {
+	var s masterSnapshot
+	if err := json.Unmarshal(data, &s.Masters); err != nil {
+		return nil, err
+	}
+
+	state, err := json.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(snapshotEnvelope{Version: 1, State: state})
+}
+
+// dataVersion returns the version of the encoded log or snapshot, 0 if it has no version.
+func dataVersion(data []byte) (int, error) {
+	if len(data) > 0 && data[0] == '[' {
+		return 0, nil
+	}
+
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, err
+	}
+	return v.Version, nil
+}
+
+// migrate migrates the data to the current version.
+func migrate(kind string, data []byte, migrations []func([]byte) ([]byte, error)) ([]byte, error) {
+	version, err := dataVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > stateVersion || version < 0 {
+		return nil, fmt.Errorf("%s version %d is not supported, the max version is %d, upgrade redis-failover", kind, version, stateVersion)
+	}
+
+	for ; version < stateVersion; version++ {
+		if data, err = migrations[version](data); err != nil {
+			return nil, fmt.Errorf("migrate %s from version %d err %v", kind, version, err)
+		}
+	}
+	return data, nil
+}
+
+func encodeCommand(a *action) ([]byte, error) {
+	d, err := json.Marshal(commandData{
+		Masters:     a.Masters,
+		Options:     a.Options,
+		Maintenance: a.Maintenance,
+		Slaves:      a.Slaves,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(command{Version: stateVersion, Cmd: a.Cmd, Data: d})
+}
+
+func decodeCommand(data []byte) (*action, error) {
+	data, err := migrate("command", data, commandMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var c command
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	var d commandData
+	if len(c.Data) > 0 {
+		if err := json.Unmarshal(c.Data, &d); err != nil {
+			return nil, err
+		}
+	}
+
+	return &action{
+		Cmd:         c.Cmd,
+		Masters:     d.Masters,
+		Options:     d.Options,
+		Maintenance: d.Maintenance,
+		Slaves:      d.Slaves,
+	}, nil
+}
+
+func encodeSnapshot(s *masterSnapshot) ([]byte, error) {
+	state, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(snapshotEnvelope{Version: stateVersion, State: state})
+}
+
+func decodeSnapshot(data []byte) (*masterSnapshot, error) {
+	data, err := migrate("snapshot", data, snapshotMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var e snapshotEnvelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	s := new(masterSnapshot)
+	if len(e.State) > 0 {
+		if err := json.Unmarshal(e.State, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,153 @@
+package failover
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	. "gopkg.in/check.v1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+type stateTestSuite struct {
+}
+
+var _ = Suite(&stateTestSuite{})
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func readLines(c *C, name string) [][]byte {
+	f, err := os.Open(filepath.Join("testdata", name))
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	var lines [][]byte
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, append([]byte(nil), s.Bytes()...))
+	}
+	c.Assert(s.Err(), IsNil)
+	return lines
+}
+
+func replay(c *C, lines [][]byte) *masterFSM {
+	fsm := newMasterFSM()
+	for i, line := range lines {
+		c.Assert(fsm.Apply(&raft.Log{Index: uint64(i + 1), Data: line}), IsNil)
+	}
+	return fsm
+}
+
+func restore(c *C, data []byte) *masterFSM {
+	fsm := newMasterFSM()
+	c.Assert(fsm.Restore(ioutil.NopCloser(bytes.NewReader(data))), IsNil)
+	return fsm
+}
+
+func checkGolden(c *C, name string, data []byte) {
+	name = filepath.Join("testdata", name)
+	if *updateGolden {
+		c.Assert(ioutil.WriteFile(name, data, 0644), IsNil)
+	}
+
+	golden, err := ioutil.ReadFile(name)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, string(golden))
+}
+
+// the state of testdata/raft_v1.log
+func checkV1State(c *C, fsm *masterFSM) {
+	c.Assert(fsm.Copy().masters, DeepEquals, map[string]struct{}{"127.0.0.1:6379": {}, "127.0.0.1:6380": {}})
+	c.Assert(fsm.GetOptions("127.0.0.1:6379"), Equals, GroupOptions{MaxDownTime: 5, Fence: FencePause})
+	c.Assert(fsm.GetMaintenance("127.0.0.1:6380"), DeepEquals, &Maintenance{Since: time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), Reason: "upgrade"})
+	c.Assert(fsm.GetSlaves("127.0.0.1:6379"), DeepEquals, []string{"127.0.0.1:6381"})
+}
+
+func (s *stateTestSuite) TestReplayOldLog(c *C) {
+	// the version 0 log only has add, set and del
+	v0 := readLines(c, "raft_v0.log")
+	fsm := replay(c, v0)
+	c.Assert(fsm.Copy().masters, DeepEquals, map[string]struct{}{"127.0.0.1:6379": {}, "127.0.0.1:6380": {}})
+	c.Assert(fsm.GetAllOptions(), HasLen, 0)
+
+	v1 := readLines(c, "raft_v1.log")
+
+	// the migrated log is the same as the current encoding
+	var migrated []string
+	for _, line := range v0 {
+		a, err := decodeCommand(line)
+		c.Assert(err, IsNil)
+
+		data, err := encodeCommand(a)
+		c.Assert(err, IsNil)
+
+		m, err := migrate("command", line, commandMigrations)
+		c.Assert(err, IsNil)
+		c.Assert(string(m), Equals, string(data))
+
+		migrated = append(migrated, string(m))
+	}
+	c.Assert(migrated, DeepEquals, []string{string(v1[0]), string(v1[1]), string(v1[5])})
+
+	var data []byte
+	for _, line := range v1 {
+		a, err := decodeCommand(line)
+		c.Assert(err, IsNil)
+
+		encoded, err := encodeCommand(a)
+		c.Assert(err, IsNil)
+		data = append(append(data, encoded...), '\n')
+	}
+	checkGolden(c, "raft_v1.log", data)
+
+	checkV1State(c, replay(c, v1))
+
+	// the log mixed with the old and new versions after upgrade
+	checkV1State(c, replay(c, append(append(v0[:2:2], v1[2:5]...), v0[2])))
+}
+
+func (s *stateTestSuite) TestRestoreOldSnapshot(c *C) {
+	// the version 0 snapshot only has the masters array
+	data, err := ioutil.ReadFile("testdata/raft_v0.snap")
+	c.Assert(err, IsNil)
+	fsm := restore(c, data)
+	c.Assert(fsm.GetMasters(), HasLen, 2)
+	c.Assert(fsm.GetAllOptions(), HasLen, 0)
+
+	// the snapshot of the current state
+	snap, err := replay(c, readLines(c, "raft_v1.log")).Snapshot()
+	c.Assert(err, IsNil)
+
+	sink := new(bufferSink)
+	c.Assert(snap.Persist(sink), IsNil)
+	checkGolden(c, "raft_v1.snap", sink.Bytes())
+
+	checkV1State(c, restore(c, sink.Bytes()))
+}
+
+func (s *stateTestSuite) TestFutureVersion(c *C) {
+	fsm := newMasterFSM()
+
+	err := fsm.Apply(&raft.Log{Data: []byte(`{"version": 2, "cmd": "add", "data": {"masters": ["127.0.0.1:6379"]}}`)})
+	c.Assert(err, NotNil)
+	c.Assert(fsm.GetMasters(), HasLen, 0)
+
+	err = fsm.Restore(ioutil.NopCloser(bytes.NewBufferString(`{"version": 2, "state": {}}`)))
+	c.Assert(err, NotNil)
+
+	// the unknown fields of the same version are ignored
+	c.Assert(fsm.Apply(&raft.Log{Data: []byte(`{"version": 1, "cmd": "add", "data": {"masters": ["127.0.0.1:6379"], "group": "a"}}`)}), IsNil)
+	c.Assert(fsm.IsMaster("127.0.0.1:6379"), Equals, true)
+}
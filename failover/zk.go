@@ -103,10 +103,23 @@ func (z *Zk) IsLeader() bool {
 	return z.isLeader.Get()
 }
 
+// Leader returns the leader address if we know it, now only returns self.
+func (z *Zk) Leader() string {
+	if z.IsLeader() {
+		return z.c.Addr
+	}
+	return ""
+}
+
 func (z *Zk) AddMasters(addrs []string, timeout time.Duration) error {
+	return z.AddMastersWithOptions(addrs, nil, timeout)
+}
+
+func (z *Zk) AddMastersWithOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
 	var a = action{
 		Cmd:     addCmd,
 		Masters: addrs,
+		Options: opts,
 	}
 
 	return z.apply(&a, timeout)
@@ -130,6 +143,36 @@ func (z *Zk) SetMasters(addrs []string, timeout time.Duration) error {
 	return z.apply(&a, timeout)
 }
 
+func (z *Zk) SetGroupOptions(addrs []string, opts *GroupOptions, timeout time.Duration) error {
+	var a = action{
+		Cmd:     optCmd,
+		Masters: addrs,
+		Options: opts,
+	}
+
+	return z.apply(&a, timeout)
+}
+
+func (z *Zk) SetMaintenance(addrs []string, m *Maintenance, timeout time.Duration) error {
+	var a = action{
+		Cmd:         maintCmd,
+		Masters:     addrs,
+		Maintenance: m,
+	}
+
+	return z.apply(&a, timeout)
+}
+
+func (z *Zk) SetSlaves(master string, slaves []string, timeout time.Duration) error {
+	var a = action{
+		Cmd:     slaveCmd,
+		Masters: []string{master},
+		Slaves:  slaves,
+	}
+
+	return z.apply(&a, timeout)
+}
+
 func (z *Zk) apply(a *action, timeout time.Duration) error {
 	if !z.IsLeader() {
 		return fmt.Errorf("node is not leader now")
@@ -204,19 +247,34 @@ func (z *Zk) checkLeader() {
 	}()
 }
 
-func (z *Zk) getMasters() error {
-	zkPath := fmt.Sprintf("%s/masters", z.c.Zk.BaseDir)
+// getNode gets the data of the node in base dir, creates it if not exists.
+func (z *Zk) getNode(name string) ([]byte, error) {
+	zkPath := fmt.Sprintf("%s/%s", z.c.Zk.BaseDir, name)
 
 	exists, _, err := z.conn.Exists(zkPath)
 	if err != nil {
-		return err
+		return nil, err
 	} else if !exists {
 		if _, err = z.conn.Create(zkPath, nil, 0, zkhelper.DefaultFileACLs()); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	data, _, err := z.conn.Get(zkPath)
+	return data, err
+}
+
+func (z *Zk) setNode(name string, v interface{}) error {
+	data, _ := json.Marshal(v)
+
+	zkPath := fmt.Sprintf("%s/%s", z.c.Zk.BaseDir, name)
+
+	_, err := z.conn.Set(zkPath, data, -1)
+	return err
+}
+
+func (z *Zk) getMasters() error {
+	data, err := z.getNode("masters")
 	if err != nil {
 		return err
 	}
@@ -229,6 +287,48 @@ func (z *Zk) getMasters() error {
 
 		z.fsm.SetMasters(masters)
 	}
+
+	data, err = z.getNode("options")
+	if err != nil {
+		return err
+	}
+
+	var options map[string]GroupOptions
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &options); err != nil {
+			return err
+		}
+	}
+
+	z.fsm.ReplaceOptions(options)
+
+	data, err = z.getNode("maintenance")
+	if err != nil {
+		return err
+	}
+
+	var maintenance map[string]Maintenance
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &maintenance); err != nil {
+			return err
+		}
+	}
+
+	z.fsm.ReplaceMaintenance(maintenance)
+
+	data, err = z.getNode("slaves")
+	if err != nil {
+		return err
+	}
+
+	var slaves map[string][]string
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &slaves); err != nil {
+			return err
+		}
+	}
+
+	z.fsm.ReplaceSlaves(slaves)
 	return nil
 }
 
@@ -240,16 +340,35 @@ func (z *Zk) handleAction(a *action) error {
 	m.handleAction(a)
 
 	masters := m.GetMasters()
-	data, _ := json.Marshal(masters)
+	if err := z.setNode("masters", masters); err != nil {
+		return err
+	}
 
-	zkPath := fmt.Sprintf("%s/masters", z.c.Zk.BaseDir)
+	options := m.GetAllOptions()
+	if err := z.setNode("options", options); err != nil {
+		return err
+	}
 
-	_, err := z.conn.Set(zkPath, data, -1)
-	if err != nil {
+	maintenance := m.GetAllMaintenance()
+	if err := z.setNode("maintenance", maintenance); err != nil {
+		return err
+	}
+
+	slaves := m.GetAllSlaves()
+	if err := z.setNode("slaves", slaves); err != nil {
 		return err
 	}
 
 	z.fsm.SetMasters(masters)
+	z.fsm.ReplaceOptions(options)
+	z.fsm.ReplaceSlaves(slaves)
+
+	// apply the action again for the maintenance events
+	if a.Cmd == maintCmd {
+		z.fsm.SetMaintenance(a.Masters, a.Maintenance)
+	} else {
+		z.fsm.ReplaceMaintenance(maintenance)
+	}
 	return nil
 }
 
@@ -5,13 +5,13 @@ go 1.14
 require (
 	github.com/BurntSushi/toml v0.2.0
 	github.com/armon/go-metrics v0.0.0-20160717043458-3df31a1ada83 // indirect
-	github.com/boltdb/bolt v1.3.1-0.20160913165339-fff57c100f4d // indirect
+	github.com/boltdb/bolt v1.3.1-0.20160913165339-fff57c100f4d
 	github.com/garyburd/redigo v1.0.0
 	github.com/go-cloud/go-zookeeper v0.0.0-20150212090419-a75bd3e76886
 	github.com/go-cloud/zkhelper v0.0.0-20150213103008-c0db2971045e
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v0.0.0-20160317213430-0eeaf8392f5b
-	github.com/hashicorp/go-msgpack v0.0.0-20150518234257-fa3f63826f7c // indirect
+	github.com/hashicorp/go-msgpack v0.0.0-20150518234257-fa3f63826f7c
 	github.com/hashicorp/raft v0.0.0-20160824023112-5f09c4ffdbcd
 	github.com/hashicorp/raft-boltdb v0.0.0-20160913163600-a8adffd05b79
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
@@ -12,9 +12,11 @@ import (
 )
 
 var configFile = flag.String("config", "", "failover config file")
+var checkConfig = flag.Bool("check-config", false, "check the config and flags, print all errors and exit")
 var addr = flag.String("addr", "", "failover http listen addr")
 var checkInterval = flag.Int("check_interval", 0, "check master alive every n millisecond")
 var maxDownTime = flag.Int("max_down_time", 0, "max down time for a master, after that, we will do failover")
+var observeOnly = flag.Bool("observe_only", false, "only elect the candidate and report it for a down master, never do failover")
 
 var masters = flag.String("masters", "", "redis master need to be monitored, seperated by comma")
 var mastersState = flag.String("masters_state", "", "new or existing for raft, if new, we will depracted old saved masters")
@@ -31,15 +33,98 @@ var zkAddr = flag.String("zk_addr", "", "zookeeper address, seperated by comma")
 var zkPath = flag.String("zk_path", "", "base directory in zk, prefix must be /zk")
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load failover config %s err\n", *configFile)
+		printConfigError(err)
+		if *checkConfig {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *checkConfig {
+		os.Exit(runCheckConfig(c))
+	}
+
+	app, err := failover.NewApp(c)
+	if err != nil {
+		fmt.Printf("new failover app error %v", err)
+		return
+	}
+
+	app.SetConfigLoader(loadConfig)
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc,
+		os.Kill,
+		os.Interrupt,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+
+	go func() {
+		for {
+			sig := <-sc
+			if sig == syscall.SIGHUP {
+				// error is logged in reload
+				app.Reload()
+				continue
+			}
+
+			app.Close()
+			return
+		}
+	}()
+
+	app.Run()
+}
+
+// runCheckConfig prints all config errors, returns the exit code.
+func runCheckConfig(c *failover.Config) int {
+	err := c.Validate()
+	if err == nil {
+		fmt.Println("config ok")
+		return 0
+	}
+
+	printConfigError(err)
+	return 1
+}
+
+// printConfigError prints every error of the ConfigError in a line to stderr.
+func printConfigError(err error) {
+	if errs, ok := err.(failover.ConfigError); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// loadConfig loads the config file and overwrites it with the command line flags.
+func loadConfig() (*failover.Config, error) {
 	var c *failover.Config
 	var err error
 	if len(*configFile) > 0 {
 		c, err = failover.NewConfigWithFile(*configFile)
 		if err != nil {
-			fmt.Printf("load failover config %s err %v", *configFile, err)
-			return
+			return nil, err
 		}
 	} else {
 		fmt.Printf("no config file, use default config")
@@ -61,6 +146,10 @@ func main() {
 		c.MaxDownTime = *maxDownTime
 	}
 
+	if *observeOnly {
+		c.ObserveOnly = true
+	}
+
 	if len(*raftAddr) > 0 {
 		c.Raft.Addr = *raftAddr
 	}
@@ -104,25 +193,5 @@ func main() {
 		c.MastersState = *mastersState
 	}
 
-	app, err := failover.NewApp(c)
-	if err != nil {
-		fmt.Printf("new failover app error %v", err)
-		return
-	}
-
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc,
-		os.Kill,
-		os.Interrupt,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGQUIT)
-
-	go func() {
-		<-sc
-		app.Close()
-	}()
-
-	app.Run()
+	return c, nil
 }